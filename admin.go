@@ -0,0 +1,22 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handleUnlockUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, 404, "error parsing user id", err)
+		return
+	}
+	user, err := cfg.dbQueries.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, 404, "user not found", err)
+		return
+	}
+	cfg.accountLoginThrottle.Reset(accountKey(user.Email))
+	respondWithJSON(w, 204, nil)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/raffkelly/chirpy/internal/auth"
+	"github.com/raffkelly/chirpy/internal/database"
+)
+
+type APIKey struct {
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	Token      string     `json:"token,omitempty"`
+}
+
+func nullTimePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+func apiKeyFromDB(key database.ApiKey) APIKey {
+	return APIKey{
+		ID:         key.ID,
+		CreatedAt:  key.CreatedAt,
+		Name:       key.Name,
+		Prefix:     key.TokenPrefix,
+		Scopes:     key.Scopes,
+		ExpiresAt:  nullTimePtr(key.ExpiresAt),
+		LastUsedAt: nullTimePtr(key.LastUsedAt),
+		RevokedAt:  nullTimePtr(key.RevokedAt),
+	}
+}
+
+// validateAccessToken accepts either a JWT or a personal access token from
+// the Authorization header. Handlers check the returned scopes.
+func (cfg *apiConfig) validateAccessToken(ctx context.Context, tokenString string) (auth.AccessClaims, error) {
+	if !auth.IsPersonalAccessToken(tokenString) {
+		return auth.ValidateAccessToken(tokenString, cfg.secret)
+	}
+	key, err := cfg.dbQueries.GetAPIKeyFromHash(ctx, auth.HashToken(tokenString))
+	if err != nil {
+		return auth.AccessClaims{}, errors.New("unknown api key")
+	}
+	if key.RevokedAt.Valid {
+		return auth.AccessClaims{}, errors.New("api key revoked")
+	}
+	if key.ExpiresAt.Valid && time.Now().After(key.ExpiresAt.Time) {
+		return auth.AccessClaims{}, errors.New("api key expired")
+	}
+	err = cfg.dbQueries.TouchAPIKey(ctx, key.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "error updating api key last use", "error", err)
+	}
+	return auth.AccessClaims{
+		UserID:   key.UserID,
+		TokenID:  key.ID.String(),
+		APIKeyID: key.ID,
+		Scopes:   key.Scopes,
+	}, nil
+}
+
+func (cfg *apiConfig) handleCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	userID := currentPrincipal(r).UserID
+	type parameters struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expires_in_days"`
+	}
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "error decoding", err)
+		return
+	}
+	if params.Name == "" {
+		respondWithError(w, http.StatusBadRequest, "name is required", nil)
+		return
+	}
+	if len(params.Scopes) == 0 {
+		respondWithError(w, http.StatusBadRequest, "at least one scope is required", nil)
+		return
+	}
+	for _, scope := range params.Scopes {
+		if !slices.Contains(auth.KnownScopes, scope) {
+			respondWithError(w, http.StatusBadRequest, "unknown scope "+scope, nil)
+			return
+		}
+	}
+	if params.ExpiresInDays < 0 {
+		respondWithError(w, http.StatusBadRequest, "expires_in_days must be positive", nil)
+		return
+	}
+	var expiresAt sql.NullTime
+	if params.ExpiresInDays > 0 {
+		expiresAt = sql.NullTime{Time: time.Now().AddDate(0, 0, params.ExpiresInDays), Valid: true}
+	}
+
+	token, err := auth.MakePersonalAccessToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "error creating api key", err)
+		return
+	}
+	keyParams := database.CreateAPIKeyParams{
+		UserID:      userID,
+		Name:        params.Name,
+		TokenHash:   auth.HashToken(token),
+		TokenPrefix: auth.PersonalAccessTokenHint(token),
+		Scopes:      params.Scopes,
+		ExpiresAt:   expiresAt,
+	}
+	key, err := cfg.dbQueries.CreateAPIKey(r.Context(), keyParams)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "error creating api key in db", err)
+		return
+	}
+	// the only time the plaintext token is ever returned
+	returnedKey := apiKeyFromDB(key)
+	returnedKey.Token = token
+	respondWithJSON(w, http.StatusCreated, returnedKey)
+}
+
+func (cfg *apiConfig) handleListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	userID := currentPrincipal(r).UserID
+	keys, err := cfg.dbQueries.GetAPIKeysByUserID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "error retrieving api keys from db", err)
+		return
+	}
+	responseKeys := make([]APIKey, len(keys))
+	for i, key := range keys {
+		responseKeys[i] = apiKeyFromDB(key)
+	}
+	respondWithJSON(w, 200, responseKeys)
+}
+
+func (cfg *apiConfig) handleRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	userID := currentPrincipal(r).UserID
+	keyID, err := uuid.Parse(r.PathValue("keyID"))
+	if err != nil {
+		respondWithError(w, 404, "error parsing key id", err)
+		return
+	}
+	revokeParams := database.RevokeAPIKeyParams{
+		ID:     keyID,
+		UserID: userID,
+	}
+	rows, err := cfg.dbQueries.RevokeAPIKey(r.Context(), revokeParams)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "error revoking api key", err)
+		return
+	}
+	if rows == 0 {
+		respondWithError(w, 404, "api key not found", nil)
+		return
+	}
+	respondWithJSON(w, 204, nil)
+}
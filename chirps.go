@@ -1,13 +1,13 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"sort"
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/raffkelly/chirpy/internal/auth"
 	"github.com/raffkelly/chirpy/internal/database"
 )
 
@@ -20,27 +20,40 @@ type Chirp struct {
 }
 
 func (cfg *apiConfig) handlerCreateChirp(w http.ResponseWriter, r *http.Request) {
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "no token found for user", err)
-	}
-	userIDfromJWT, err := auth.ValidateJWT(token, cfg.secret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "invalid token", err)
+	userIDfromJWT := currentPrincipal(r).UserID
+	if !cfg.checkEmailVerified(w, r, userIDfromJWT, "chirp") {
 		return
 	}
 	params := Chirp{}
 	decoder := json.NewDecoder(r.Body)
-	err = decoder.Decode(&params)
+	err := decoder.Decode(&params)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "error decoding", err)
 		return
 	}
 
-	if len(params.Body) > 140 {
+	entitlements, err := cfg.entitlementsFor(r.Context(), userIDfromJWT)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "error retrieving entitlements", err)
+		return
+	}
+	if len(params.Body) > entitlements.MaxChirpLength {
 		respondWithError(w, http.StatusBadRequest, "Chirp is too long", nil)
 		return
 	}
+	countParams := database.CountChirpsByUserSinceParams{
+		UserID:    userIDfromJWT,
+		CreatedAt: time.Now().Add(-time.Hour),
+	}
+	recentChirps, err := cfg.dbQueries.CountChirpsByUserSince(r.Context(), countParams)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "error counting recent chirps", err)
+		return
+	}
+	if recentChirps >= int64(entitlements.ChirpsPerHour) {
+		respondWithError(w, http.StatusTooManyRequests, "hourly chirp limit reached", nil)
+		return
+	}
 
 	params.Body = removeProfanity(params.Body)
 
@@ -49,11 +62,27 @@ func (cfg *apiConfig) handlerCreateChirp(w http.ResponseWriter, r *http.Request)
 		UserID: userIDfromJWT,
 	}
 
-	interChirp, err := cfg.dbQueries.CreateChirp(r.Context(), postingParams)
+	postedChirp, err := cfg.createChirp(r.Context(), postingParams)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "unable to create chirp in database", err)
 		return
 	}
+	respondWithJSON(w, http.StatusCreated, postedChirp)
+}
+
+// createChirp stores a chirp and queues its webhook event in one
+// transaction, so subscribers hear about exactly the chirps that exist.
+func (cfg *apiConfig) createChirp(ctx context.Context, params database.CreateChirpParams) (Chirp, error) {
+	tx, err := cfg.db.Begin(ctx)
+	if err != nil {
+		return Chirp{}, err
+	}
+	defer tx.Rollback(ctx)
+	qtx := cfg.dbQueries.WithTx(tx)
+	interChirp, err := qtx.CreateChirp(ctx, params)
+	if err != nil {
+		return Chirp{}, err
+	}
 	postedChirp := Chirp{
 		ID:        interChirp.ID,
 		CreatedAt: interChirp.CreatedAt,
@@ -61,7 +90,19 @@ func (cfg *apiConfig) handlerCreateChirp(w http.ResponseWriter, r *http.Request)
 		Body:      interChirp.Body,
 		UserID:    interChirp.UserID,
 	}
-	respondWithJSON(w, http.StatusCreated, postedChirp)
+	queued, err := queueEvent(ctx, qtx, eventChirpCreated, postedChirp)
+	if err != nil {
+		return Chirp{}, err
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		return Chirp{}, err
+	}
+	cfg.metrics.ChirpsCreated.Inc()
+	if queued {
+		cfg.wakeWebhookWorkers(ctx)
+	}
+	return postedChirp, nil
 }
 
 func (cfg *apiConfig) handlerGetChirps(w http.ResponseWriter, r *http.Request) {
@@ -126,31 +167,112 @@ func (cfg *apiConfig) handlerGetChirp(w http.ResponseWriter, r *http.Request) {
 }
 
 func (cfg *apiConfig) handleDeleteChirp(w http.ResponseWriter, r *http.Request) {
-	tokenString, err := auth.GetBearerToken(r.Header)
+	userID := currentPrincipal(r).UserID
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
 	if err != nil {
-		respondWithError(w, 401, "token missing", err)
+		respondWithError(w, http.StatusInternalServerError, "error parsing chirpID from request", err)
 		return
 	}
-	userID, err := auth.ValidateJWT(tokenString, cfg.secret)
-
+	chirp, err := cfg.dbQueries.GetChirp(r.Context(), chirpID)
+	if chirp.UserID != userID {
+		respondWithError(w, 403, "user not authorized to delete chirp", err)
+		return
+	}
+	err = cfg.deleteChirp(r.Context(), chirp)
 	if err != nil {
-		respondWithError(w, 403, "invalid token", err)
+		respondWithError(w, 404, "chirp not found", err)
 		return
 	}
+	respondWithJSON(w, 204, nil)
+}
+
+// deleteChirp removes a chirp and tells webhook subscribers, for both the
+// author's request and moderation from the admin command. The event is
+// queued in the same transaction as the delete.
+func (cfg *apiConfig) deleteChirp(ctx context.Context, chirp database.Chirp) error {
+	tx, err := cfg.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	qtx := cfg.dbQueries.WithTx(tx)
+	err = qtx.DeleteChirp(ctx, chirp.ID)
+	if err != nil {
+		return err
+	}
+	queued, err := queueEvent(ctx, qtx, eventChirpDeleted, Chirp{
+		ID:        chirp.ID,
+		CreatedAt: chirp.CreatedAt,
+		UpdatedAt: chirp.UpdatedAt,
+		Body:      chirp.Body,
+		UserID:    chirp.UserID,
+	})
+	if err != nil {
+		return err
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+	if queued {
+		cfg.wakeWebhookWorkers(ctx)
+	}
+	return nil
+}
+
+func (cfg *apiConfig) handleUpdateChirp(w http.ResponseWriter, r *http.Request) {
+	userID := currentPrincipal(r).UserID
 	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "error parsing chirpID from request", err)
+		respondWithError(w, 404, "error parsing chirp id", err)
+		return
+	}
+	type parameters struct {
+		Body string `json:"body"`
+	}
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "error decoding", err)
+		return
+	}
+	entitlements, err := cfg.entitlementsFor(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "error retrieving entitlements", err)
+		return
+	}
+	if !entitlements.EditChirps {
+		respondWithError(w, 403, "editing chirps requires Chirpy Red", nil)
 		return
 	}
 	chirp, err := cfg.dbQueries.GetChirp(r.Context(), chirpID)
+	if err != nil {
+		respondWithError(w, 404, "chirp not found", err)
+		return
+	}
 	if chirp.UserID != userID {
-		respondWithError(w, 403, "user not authorized to delete chirp", err)
+		respondWithError(w, 403, "user not authorized to edit chirp", nil)
 		return
 	}
-	err = cfg.dbQueries.DeleteChirp(r.Context(), chirpID)
+	if len(params.Body) > entitlements.MaxChirpLength {
+		respondWithError(w, http.StatusBadRequest, "Chirp is too long", nil)
+		return
+	}
+	updateParams := database.UpdateChirpParams{
+		ID:   chirpID,
+		Body: removeProfanity(params.Body),
+	}
+	updatedChirp, err := cfg.dbQueries.UpdateChirp(r.Context(), updateParams)
 	if err != nil {
-		respondWithError(w, 404, "chirp not found", err)
+		respondWithError(w, http.StatusInternalServerError, "error updating chirp in db", err)
 		return
 	}
-	respondWithJSON(w, 204, nil)
+	respondWithJSON(w, 200, Chirp{
+		ID:        updatedChirp.ID,
+		CreatedAt: updatedChirp.CreatedAt,
+		UpdatedAt: updatedChirp.UpdatedAt,
+		Body:      updatedChirp.Body,
+		UserID:    updatedChirp.UserID,
+	})
 }
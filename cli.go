@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/raffkelly/chirpy/internal/config"
+	"github.com/raffkelly/chirpy/internal/database"
+)
+
+func usage() {
+	fmt.Fprint(flag.CommandLine.Output(), `Usage: chirpy [--config FILE] [--print-config] [command]
+
+Commands:
+  serve                              run the HTTP server (the default)
+  migrate up|down|status|to VERSION  manage the database schema
+  user create [--admin] EMAIL        create a user; the password is read from stdin
+  user list                          list all users
+  user promote EMAIL                 give a user admin access
+  user suspend EMAIL                 block a user's logins and tokens
+  user unsuspend EMAIL               lift a suspension
+  token revoke TOKEN                 revoke one refresh token
+  token revoke --user EMAIL          revoke all of a user's refresh tokens
+  chirp delete CHIRP_ID              delete a chirp, e.g. for moderation
+  chirp import EMAIL FILE            post each line of FILE as a chirp by EMAIL
+  seed                               create demo users and chirps (PLATFORM=dev only)
+
+Flags:
+`)
+	flag.PrintDefaults()
+}
+
+// runCommand runs an admin command against the database with the same
+// apiConfig the server uses, so the API's validation rules apply.
+func runCommand(conf *config.Config, command string, args []string) error {
+	commands := map[string]func(context.Context, *apiConfig, []string) error{
+		"user":  runUserCommand,
+		"token": runTokenCommand,
+		"chirp": runChirpCommand,
+		"seed":  runSeed,
+	}
+	run, ok := commands[command]
+	if !ok {
+		return fmt.Errorf("unknown command %q, see chirpy --help", command)
+	}
+
+	ctx := context.Background()
+	db, err := openDB(ctx, conf)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	err = prepareSchema(ctx, db, false)
+	if err != nil {
+		return err
+	}
+	cfg, err := newAPIConfig(conf, db)
+	if err != nil {
+		return err
+	}
+	return run(ctx, cfg, args)
+}
+
+func runUserCommand(ctx context.Context, cfg *apiConfig, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: chirpy user create|list|promote|suspend|unsuspend")
+	}
+	switch args[0] {
+	case "create":
+		flags := flag.NewFlagSet("user create", flag.ContinueOnError)
+		admin := flags.Bool("admin", false, "give the user admin access")
+		err := flags.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+		if flags.NArg() != 1 {
+			return errors.New("usage: chirpy user create [--admin] EMAIL")
+		}
+		password, err := readPassword(os.Stdin)
+		if err != nil {
+			return err
+		}
+		user, err := cfg.createUser(ctx, flags.Arg(0), password)
+		if err != nil {
+			return err
+		}
+		if *admin {
+			err = cfg.dbQueries.SetUserAdmin(ctx, database.SetUserAdminParams{ID: user.ID, IsAdmin: true})
+			if err != nil {
+				return err
+			}
+		}
+		fmt.Printf("created user %s (%s)\n", user.Email, user.ID)
+		return nil
+	case "list":
+		users, err := cfg.dbQueries.ListUsers(ctx)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tEMAIL\tCREATED\tADMIN\tCHIRPY RED\tSUSPENDED")
+		for _, user := range users {
+			suspended := ""
+			if user.SuspendedAt.Valid {
+				suspended = user.SuspendedAt.Time.Format(time.DateTime)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%t\t%t\t%s\n",
+				user.ID, user.Email, user.CreatedAt.Format(time.DateTime), user.IsAdmin, user.IsChirpyRed, suspended)
+		}
+		return tw.Flush()
+	case "promote", "suspend", "unsuspend":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: chirpy user %s EMAIL", args[0])
+		}
+		user, err := cfg.dbQueries.GetUserFromEmail(ctx, args[1])
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("no user with email %s", args[1])
+		}
+		if err != nil {
+			return err
+		}
+		switch args[0] {
+		case "promote":
+			err = cfg.dbQueries.SetUserAdmin(ctx, database.SetUserAdminParams{ID: user.ID, IsAdmin: true})
+		case "suspend":
+			err = cfg.suspendUser(ctx, user.ID)
+		case "unsuspend":
+			err = cfg.dbQueries.SetUserSuspended(ctx, database.SetUserSuspendedParams{ID: user.ID})
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s: %s\n", args[0], user.Email)
+		return nil
+	}
+	return fmt.Errorf("unknown user command %q", args[0])
+}
+
+// suspendUser blocks the account and revokes its refresh tokens. Access
+// tokens and API keys stop working because middlewareAuth checks the
+// suspension on every request.
+func (cfg *apiConfig) suspendUser(ctx context.Context, userID uuid.UUID) error {
+	tx, err := cfg.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	qtx := cfg.dbQueries.WithTx(tx)
+	suspendParams := database.SetUserSuspendedParams{
+		ID:          userID,
+		SuspendedAt: sql.NullTime{Time: time.Now(), Valid: true},
+	}
+	err = qtx.SetUserSuspended(ctx, suspendParams)
+	if err != nil {
+		return err
+	}
+	_, err = qtx.RevokeUserRefreshTokens(ctx, userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// readPassword reads one line, prompting when stdin is a terminal. Piping
+// keeps the password out of shell history and the process list.
+func readPassword(stdin *os.File) (string, error) {
+	info, err := stdin.Stat()
+	if err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		fmt.Fprint(os.Stderr, "Password: ")
+	}
+	line, err := bufio.NewReader(stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func runTokenCommand(ctx context.Context, cfg *apiConfig, args []string) error {
+	if len(args) == 0 || args[0] != "revoke" {
+		return errors.New("usage: chirpy token revoke TOKEN | --user EMAIL")
+	}
+	flags := flag.NewFlagSet("token revoke", flag.ContinueOnError)
+	email := flags.String("user", "", "revoke every refresh token of this user")
+	err := flags.Parse(args[1:])
+	if err != nil {
+		return err
+	}
+	if *email != "" && flags.NArg() == 0 {
+		user, err := cfg.dbQueries.GetUserFromEmail(ctx, *email)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("no user with email %s", *email)
+		}
+		if err != nil {
+			return err
+		}
+		revoked, err := cfg.dbQueries.RevokeUserRefreshTokens(ctx, user.ID)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("revoked %d refresh tokens for %s\n", revoked, user.Email)
+		return nil
+	}
+	if *email != "" || flags.NArg() != 1 {
+		return errors.New("usage: chirpy token revoke TOKEN | --user EMAIL")
+	}
+	token, err := cfg.dbQueries.GetRefreshToken(ctx, flags.Arg(0))
+	if errors.Is(err, sql.ErrNoRows) {
+		return errors.New("refresh token not found")
+	}
+	if err != nil {
+		return err
+	}
+	err = cfg.dbQueries.RevokeToken(ctx, token.Token)
+	if err != nil {
+		return err
+	}
+	fmt.Println("revoked refresh token")
+	return nil
+}
+
+func runChirpCommand(ctx context.Context, cfg *apiConfig, args []string) error {
+	if len(args) == 3 && args[0] == "import" {
+		return runChirpImport(ctx, cfg, args[1], args[2])
+	}
+	if len(args) != 2 || args[0] != "delete" {
+		return errors.New("usage: chirpy chirp delete CHIRP_ID | import EMAIL FILE")
+	}
+	chirpID, err := uuid.Parse(args[1])
+	if err != nil {
+		return fmt.Errorf("invalid chirp id: %w", err)
+	}
+	chirp, err := cfg.dbQueries.GetChirp(ctx, chirpID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errors.New("chirp not found")
+	}
+	if err != nil {
+		return err
+	}
+	err = cfg.deleteChirp(ctx, chirp)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("deleted chirp %s\n", chirp.ID)
+	return nil
+}
+
+// runChirpImport posts every non-empty line of a file as a chirp. The rules
+// for posting through the API apply, such as the user's length limit,
+// suspension and email verification, and profanity is masked. Only the
+// hourly limit is skipped, and no webhook events are sent for imported
+// chirps.
+func runChirpImport(ctx context.Context, cfg *apiConfig, email, path string) error {
+	user, err := cfg.dbQueries.GetUserFromEmail(ctx, email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("no user with email %s", email)
+	}
+	if err != nil {
+		return err
+	}
+	if user.SuspendedAt.Valid {
+		return fmt.Errorf("%s is suspended and can't post chirps", email)
+	}
+	if cfg.unverifiedRestrictions["chirp"] && !user.EmailVerified {
+		return fmt.Errorf("%s must verify their email before posting chirps", email)
+	}
+	entitlements, err := cfg.entitlementsFor(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var bodies []string
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		body := strings.TrimSpace(scanner.Text())
+		if body == "" {
+			continue
+		}
+		if len(body) > entitlements.MaxChirpLength {
+			return fmt.Errorf("%s:%d: chirp is longer than %d characters", path, line, entitlements.MaxChirpLength)
+		}
+		bodies = append(bodies, body)
+	}
+	err = scanner.Err()
+	if err != nil {
+		return err
+	}
+	imported, err := cfg.copyChirps(ctx, user.ID, bodies)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("imported %d chirps for %s\n", imported, user.Email)
+	return nil
+}
+
+// copyChirps inserts many chirps with a single COPY. Each is a microsecond
+// newer than the one before, so they keep their order when sorted by
+// creation time.
+func (cfg *apiConfig) copyChirps(ctx context.Context, userID uuid.UUID, bodies []string) (int64, error) {
+	now := time.Now()
+	rows := make([]database.CopyChirpsParams, len(bodies))
+	for i, body := range bodies {
+		createdAt := now.Add(time.Duration(i) * time.Microsecond)
+		rows[i] = database.CopyChirpsParams{
+			ID:        uuid.New(),
+			CreatedAt: createdAt,
+			UpdatedAt: createdAt,
+			Body:      removeProfanity(body),
+			UserID:    userID,
+		}
+	}
+	created, err := cfg.dbQueries.CopyChirps(ctx, rows)
+	if err != nil {
+		return 0, err
+	}
+	cfg.metrics.ChirpsCreated.Add(float64(created))
+	return created, nil
+}
+
+// seedUsers are demo accounts for local development, each with a few chirps.
+var seedUsers = []struct {
+	email  string
+	chirps []string
+}{
+	{"walt@example.com", []string{"Say my name.", "I am the one who knocks!"}},
+	{"jesse@example.com", []string{"Yeah, science!"}},
+	{"saul@example.com", []string{"Better call Saul!", "Did you know you have rights?"}},
+}
+
+const seedPassword = "chirpy-seed-password-1"
+
+// runSeed fills a development database with demo users and chirps. Users
+// that already exist are skipped so it can be run repeatedly.
+func runSeed(ctx context.Context, cfg *apiConfig, args []string) error {
+	if len(args) != 0 {
+		return errors.New("usage: chirpy seed")
+	}
+	if cfg.platform != "dev" {
+		return errors.New("seed only runs with PLATFORM=dev")
+	}
+	for _, seed := range seedUsers {
+		_, err := cfg.dbQueries.GetUserFromEmail(ctx, seed.email)
+		if err == nil {
+			fmt.Printf("skipping %s, already exists\n", seed.email)
+			continue
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		user, err := cfg.createUser(ctx, seed.email, seedPassword)
+		if err != nil {
+			return fmt.Errorf("creating %s: %w", seed.email, err)
+		}
+		// demo users can chirp straight away
+		_, err = cfg.dbQueries.VerifyUserEmail(ctx, database.VerifyUserEmailParams{Email: user.Email, ID: user.ID})
+		if err != nil {
+			return err
+		}
+		_, err = cfg.copyChirps(ctx, user.ID, seed.chirps)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("created %s with %d chirps\n", seed.email, len(seed.chirps))
+	}
+	fmt.Printf("seed users log in with password %q\n", seedPassword)
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/raffkelly/chirpy/internal/auth"
+	"github.com/raffkelly/chirpy/internal/database"
+)
+
+func TestCreateUserValidation(t *testing.T) {
+	cfg := &apiConfig{passwordPolicy: auth.PasswordPolicy{MinLength: 8, MinEntropyBits: 40}}
+	cases := map[string]string{
+		"not-an-email":  "correct horse battery staple",
+		"a@example.com": "short",
+	}
+	for email, password := range cases {
+		_, err := cfg.createUser(context.Background(), email, password)
+		var invalid invalidInputError
+		if !errors.As(err, &invalid) {
+			t.Errorf("%s/%s: expected invalid input, got %v", email, password, err)
+		}
+	}
+	err := cfg.passwordPolicy.Validate(seedPassword)
+	if err != nil {
+		t.Errorf("seed password rejected by the default policy: %v", err)
+	}
+}
+
+func TestReadPassword(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("pipe: %v", err)
+	}
+	w.WriteString("hunter2 hunter2\r\nignored\n")
+	w.Close()
+	password, err := readPassword(r)
+	if err != nil || password != "hunter2 hunter2" {
+		t.Fatalf("got %q, %v", password, err)
+	}
+}
+
+func TestChirpImportAppliesAccountRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chirps.txt")
+	if err := os.WriteFile(path, []byte("Say my name.\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name     string
+		user     database.User
+		expected string
+	}{
+		{"suspended user", database.User{EmailVerified: true, SuspendedAt: sql.NullTime{Time: time.Now(), Valid: true}}, "suspended"},
+		{"unverified user", database.User{}, "verify their email"},
+	}
+	for _, c := range cases {
+		c.user.Email = "walt@example.com"
+		db := &fakeDB{}
+		db.on("GetUserFromEmail", func(args ...any) (any, error) { return c.user, nil })
+		cfg := &apiConfig{
+			dbQueries:              database.New(db),
+			unverifiedRestrictions: map[string]bool{"chirp": true},
+		}
+		err := runChirpImport(context.Background(), cfg, c.user.Email, path)
+		if err == nil || !strings.Contains(err.Error(), c.expected) {
+			t.Errorf("%s: got %v, want an error about %q", c.name, err, c.expected)
+		}
+		if db.called("copy_chirps") {
+			t.Errorf("%s: chirps were imported", c.name)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/raffkelly/chirpy/internal/config"
+)
+
+// openDB opens the connection pool and waits for Postgres to answer, retrying
+// with backoff for up to DB_CONNECT_TIMEOUT. That covers the database
+// starting alongside chirpy, while a bad DB_URL still fails at startup
+// rather than on the first request.
+func openDB(ctx context.Context, conf *config.Config) (*pgxpool.Pool, error) {
+	poolConf, err := pgxpool.ParseConfig(conf.DBURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DB_URL: %w", err)
+	}
+	poolConf.MaxConns = int32(conf.DBMaxOpenConns)
+	poolConf.MinConns = int32(conf.DBMinConns)
+	poolConf.MaxConnLifetime = conf.DBConnMaxLifetime
+	poolConf.MaxConnIdleTime = conf.DBConnMaxIdleTime
+	db, err := pgxpool.NewWithConfig(ctx, poolConf)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, conf.DBConnectTimeout)
+	defer cancel()
+	backoff := 500 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		err = db.Ping(ctx)
+		if err == nil {
+			return db, nil
+		}
+		slog.Warn("database not reachable, retrying", "attempt", attempt, "retry_in", backoff, "error", err)
+		select {
+		case <-ctx.Done():
+			db.Close()
+			return nil, fmt.Errorf("database not reachable after %s: %w", conf.DBConnectTimeout, err)
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, 10*time.Second)
+	}
+}
+
+// middlewareQueryTimeout puts a deadline on each request's context. Queries
+// run with the request context, so on a struggling database they are
+// cancelled instead of holding pool connections while requests pile up.
+// Like the other middleware that derives a request, it must not sit between
+// the logging middleware and the ServeMux, or the route pattern is lost.
+func middlewareQueryTimeout(timeout time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/raffkelly/chirpy/internal/config"
+	"github.com/raffkelly/chirpy/internal/metrics"
+)
+
+func TestOpenDBGivesUp(t *testing.T) {
+	conf := &config.Config{
+		// nothing listens on port 1
+		DBURL:            "postgres://chirpy@127.0.0.1:1/chirpy?sslmode=disable",
+		DBMaxOpenConns:   1,
+		DBConnectTimeout: 700 * time.Millisecond,
+	}
+	start := time.Now()
+	_, err := openDB(context.Background(), conf)
+	if err == nil || !strings.Contains(err.Error(), "not reachable") {
+		t.Fatalf("expected unreachable error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < conf.DBConnectTimeout || elapsed > 3*time.Second {
+		t.Fatalf("gave up after %s, want about %s", elapsed, conf.DBConnectTimeout)
+	}
+}
+
+func TestMiddlewareQueryTimeout(t *testing.T) {
+	var deadline time.Time
+	handler := middlewareQueryTimeout(time.Second, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadline, _ = r.Context().Deadline()
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/chirps", nil))
+	if remaining := time.Until(deadline); remaining <= 0 || remaining > time.Second {
+		t.Fatalf("request context deadline not set from the timeout")
+	}
+}
+
+// fakeDB answers sqlc queries by name with canned handlers, so handler tests
+// run without Postgres. A handler returns a row struct or scalar for :one, a
+// slice for :many and a row count for :execrows; nil from a :one query means
+// no rows. Queries without a handler fail.
+type fakeDB struct {
+	queries map[string]func(args ...any) (any, error)
+	calls   []string
+}
+
+func (db *fakeDB) on(name string, handle func(args ...any) (any, error)) *fakeDB {
+	if db.queries == nil {
+		db.queries = make(map[string]func(args ...any) (any, error))
+	}
+	db.queries[name] = handle
+	return db
+}
+
+// called reports whether the named query ran.
+func (db *fakeDB) called(name string) bool {
+	return slices.Contains(db.calls, name)
+}
+
+func (db *fakeDB) run(name string, args []any) (any, error) {
+	db.calls = append(db.calls, name)
+	handle, ok := db.queries[name]
+	if !ok {
+		return nil, fmt.Errorf("unexpected query %s", name)
+	}
+	return handle(args...)
+}
+
+func (db *fakeDB) Exec(ctx context.Context, query string, args ...interface{}) (pgconn.CommandTag, error) {
+	result, err := db.run(metrics.QueryName(query), args)
+	if err != nil {
+		return pgconn.CommandTag{}, err
+	}
+	affected, _ := result.(int64)
+	return pgconn.NewCommandTag(fmt.Sprintf("UPDATE %d", affected)), nil
+}
+
+func (db *fakeDB) Query(ctx context.Context, query string, args ...interface{}) (pgx.Rows, error) {
+	result, err := db.run(metrics.QueryName(query), args)
+	if err != nil {
+		return nil, err
+	}
+	rows := &fakeRows{}
+	if result != nil {
+		items := reflect.ValueOf(result)
+		for i := 0; i < items.Len(); i++ {
+			rows.items = append(rows.items, items.Index(i).Interface())
+		}
+	}
+	return rows, nil
+}
+
+func (db *fakeDB) QueryRow(ctx context.Context, query string, args ...interface{}) pgx.Row {
+	result, err := db.run(metrics.QueryName(query), args)
+	return fakeRow{value: result, err: err}
+}
+
+func (db *fakeDB) CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error) {
+	var rows []any
+	for rowSrc.Next() {
+		values, err := rowSrc.Values()
+		if err != nil {
+			return 0, err
+		}
+		rows = append(rows, values)
+	}
+	_, err := db.run(metrics.CopyName(tableName), rows)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(rows)), nil
+}
+
+func (db *fakeDB) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (db *fakeDB) Begin(ctx context.Context) (pgx.Tx, error) {
+	return &fakeTx{db: db}, nil
+}
+
+// fakeTx runs queries against its fakeDB and records a commit as a "Commit"
+// call; rolling back does nothing, so tests should check what was committed.
+type fakeTx struct {
+	pgx.Tx
+	db *fakeDB
+}
+
+func (tx *fakeTx) Exec(ctx context.Context, query string, args ...interface{}) (pgconn.CommandTag, error) {
+	return tx.db.Exec(ctx, query, args...)
+}
+
+func (tx *fakeTx) Query(ctx context.Context, query string, args ...interface{}) (pgx.Rows, error) {
+	return tx.db.Query(ctx, query, args...)
+}
+
+func (tx *fakeTx) QueryRow(ctx context.Context, query string, args ...interface{}) pgx.Row {
+	return tx.db.QueryRow(ctx, query, args...)
+}
+
+func (tx *fakeTx) CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error) {
+	return tx.db.CopyFrom(ctx, tableName, columnNames, rowSrc)
+}
+
+func (tx *fakeTx) Commit(ctx context.Context) error {
+	tx.db.calls = append(tx.db.calls, "Commit")
+	return nil
+}
+
+func (tx *fakeTx) Rollback(ctx context.Context) error {
+	return nil
+}
+
+type fakeRow struct {
+	value any
+	err   error
+}
+
+func (r fakeRow) Scan(dest ...any) error {
+	if r.err != nil {
+		return r.err
+	}
+	if r.value == nil {
+		return pgx.ErrNoRows
+	}
+	return scanFake(r.value, dest)
+}
+
+// scanFake copies a canned value into Scan's destinations: the value itself
+// for a single matching destination, otherwise a struct's fields in order,
+// which is how sqlc scans its row types.
+func scanFake(value any, dest []any) error {
+	v := reflect.ValueOf(value)
+	if len(dest) == 1 {
+		target := reflect.ValueOf(dest[0]).Elem()
+		if v.Type().AssignableTo(target.Type()) {
+			target.Set(v)
+			return nil
+		}
+	}
+	if v.Kind() != reflect.Struct || v.NumField() != len(dest) {
+		return fmt.Errorf("can't scan %T into %d columns", value, len(dest))
+	}
+	for i := range dest {
+		reflect.ValueOf(dest[i]).Elem().Set(v.Field(i))
+	}
+	return nil
+}
+
+type fakeRows struct {
+	items   []any
+	current any
+}
+
+func (r *fakeRows) Close()                                       {}
+func (r *fakeRows) Err() error                                   { return nil }
+func (r *fakeRows) CommandTag() pgconn.CommandTag                { return pgconn.CommandTag{} }
+func (r *fakeRows) FieldDescriptions() []pgconn.FieldDescription { return nil }
+func (r *fakeRows) RawValues() [][]byte                          { return nil }
+func (r *fakeRows) Conn() *pgx.Conn                              { return nil }
+func (r *fakeRows) Values() ([]any, error)                       { return nil, errors.New("not supported") }
+
+func (r *fakeRows) Next() bool {
+	if len(r.items) == 0 {
+		return false
+	}
+	r.current, r.items = r.items[0], r.items[1:]
+	return true
+}
+
+func (r *fakeRows) Scan(dest ...any) error {
+	return scanFake(r.current, dest)
+}
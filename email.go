@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/raffkelly/chirpy/internal/auth"
+	"github.com/raffkelly/chirpy/internal/database"
+	"github.com/raffkelly/chirpy/internal/mailer"
+)
+
+const emailVerificationTTL = 24 * time.Hour
+
+func (cfg *apiConfig) sendVerificationEmail(ctx context.Context, userID uuid.UUID, email string) error {
+	token, err := auth.MakeEmailVerificationToken(userID, email, cfg.secret, emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+	link := fmt.Sprintf("%s/api/email/verify?token=%s", cfg.baseURL, url.QueryEscape(token))
+	return cfg.mailer.Send(ctx, mailer.Message{
+		To:      email,
+		Subject: "Verify your Chirpy email address",
+		Body:    fmt.Sprintf("Confirm this address by opening the link below within 24 hours:\n\n%s\n", link),
+	})
+}
+
+// checkEmailVerified writes a 403 and returns false when the user has not
+// verified their email and action is restricted until they do.
+func (cfg *apiConfig) checkEmailVerified(w http.ResponseWriter, r *http.Request, userID uuid.UUID, action string) bool {
+	if !cfg.unverifiedRestrictions[action] {
+		return true
+	}
+	user, err := cfg.dbQueries.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "user not found", err)
+		return false
+	}
+	if !user.EmailVerified {
+		respondWithError(w, http.StatusForbidden, "email address must be verified first", nil)
+		return false
+	}
+	return true
+}
+
+func (cfg *apiConfig) handleVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token string `json:"token"`
+	}
+	params := parameters{Token: r.URL.Query().Get("token")}
+	if params.Token == "" {
+		decoder := json.NewDecoder(r.Body)
+		err := decoder.Decode(&params)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "verification token missing", err)
+			return
+		}
+	}
+	userID, email, err := auth.ValidateEmailVerificationToken(params.Token, cfg.secret)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid or expired verification token", err)
+		return
+	}
+	user, err := cfg.dbQueries.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "user not found", err)
+		return
+	}
+	// only the current or pending address can be confirmed, so links for an
+	// abandoned email change stop working once it is replaced
+	isPending := user.PendingEmail.Valid && user.PendingEmail.String == email
+	if !isPending && email != user.Email {
+		respondWithError(w, http.StatusBadRequest, "verification link is no longer valid", nil)
+		return
+	}
+	if !isPending && user.EmailVerified {
+		respondWithJSON(w, http.StatusOK, userFromDB(user))
+		return
+	}
+	verifyParams := database.VerifyUserEmailParams{
+		Email: email,
+		ID:    userID,
+	}
+	verifiedUser, err := cfg.dbQueries.VerifyUserEmail(r.Context(), verifyParams)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			respondWithError(w, http.StatusConflict, "email already in use", err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "error verifying email in db", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, userFromDB(verifiedUser))
+}
+
+func (cfg *apiConfig) handleResendVerification(w http.ResponseWriter, r *http.Request) {
+	userID := currentPrincipal(r).UserID
+	user, err := cfg.dbQueries.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "user not found", err)
+		return
+	}
+	var email string
+	if user.PendingEmail.Valid {
+		email = user.PendingEmail.String
+	} else if !user.EmailVerified {
+		email = user.Email
+	} else {
+		respondWithError(w, http.StatusBadRequest, "email already verified", nil)
+		return
+	}
+	err = cfg.sendVerificationEmail(r.Context(), userID, email)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "error sending verification email", err)
+		return
+	}
+	respondWithJSON(w, 204, nil)
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/raffkelly/chirpy/internal/database"
+	"github.com/raffkelly/chirpy/internal/mailer"
+	"github.com/raffkelly/chirpy/internal/metrics"
+)
+
+type recordingMailer struct {
+	sent []mailer.Message
+}
+
+func (m *recordingMailer) Send(ctx context.Context, msg mailer.Message) error {
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+func TestVerificationLinkReachesHandler(t *testing.T) {
+	mail := &recordingMailer{}
+	cfg := &apiConfig{
+		metrics:   metrics.New(),
+		dbQueries: database.New(&fakeDB{}),
+		secret:    "verification-link-test-secret-0123456789",
+		baseURL:   "http://localhost:8080",
+		mailer:    mail,
+	}
+	err := cfg.sendVerificationEmail(context.Background(), uuid.New(), "walt@example.com")
+	if err != nil || len(mail.sent) != 1 {
+		t.Fatalf("verification email not sent: %v", err)
+	}
+	link := regexp.MustCompile(`https?://\S+`).FindString(mail.sent[0].Body)
+	u, err := url.Parse(link)
+	if err != nil || link == "" {
+		t.Fatalf("no link in email body %q", mail.sent[0].Body)
+	}
+
+	recorder := httptest.NewRecorder()
+	cfg.routes().ServeHTTP(recorder, httptest.NewRequest("GET", u.RequestURI(), nil))
+	// the token was accepted; the fake database just has no such user
+	if recorder.Code != http.StatusNotFound {
+		t.Fatalf("following the emailed link got status %d: %s", recorder.Code, recorder.Body)
+	}
+}
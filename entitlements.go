@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	tierFree = "free"
+	tierRed  = "red"
+)
+
+// Entitlements are the limits and features a user's plan gives them.
+// Handlers consult these rather than checking Chirpy Red directly.
+type Entitlements struct {
+	Tier           string `json:"tier"`
+	MaxChirpLength int    `json:"max_chirp_length"`
+	EditChirps     bool   `json:"edit_chirps"`
+	ChirpsPerHour  int    `json:"chirps_per_hour"`
+	// how many chirps may be queued for later posting at once
+	ScheduledChirps int `json:"scheduled_chirps"`
+}
+
+var tierEntitlements = map[string]Entitlements{
+	tierFree: {
+		Tier:            tierFree,
+		MaxChirpLength:  140,
+		EditChirps:      false,
+		ChirpsPerHour:   30,
+		ScheduledChirps: 0,
+	},
+	tierRed: {
+		Tier:            tierRed,
+		MaxChirpLength:  500,
+		EditChirps:      true,
+		ChirpsPerHour:   300,
+		ScheduledChirps: 50,
+	},
+}
+
+// entitlementsFor derives the user's plan from their subscription, so a
+// lapsed subscription loses its features even before the expiry job runs.
+func (cfg *apiConfig) entitlementsFor(ctx context.Context, userID uuid.UUID) (Entitlements, error) {
+	subscription, err := cfg.dbQueries.GetSubscriptionByUserID(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return tierEntitlements[tierFree], nil
+	}
+	if err != nil {
+		return Entitlements{}, err
+	}
+	state := subscriptionState{Status: subscription.Status, PeriodEnd: subscription.CurrentPeriodEnd}
+	if state.grantsChirpyRed(time.Now()) {
+		return tierEntitlements[tierRed], nil
+	}
+	return tierEntitlements[tierFree], nil
+}
+
+func (cfg *apiConfig) handleGetEntitlements(w http.ResponseWriter, r *http.Request) {
+	entitlements, err := cfg.entitlementsFor(r.Context(), currentPrincipal(r).UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "error retrieving entitlements", err)
+		return
+	}
+	respondWithJSON(w, 200, entitlements)
+}
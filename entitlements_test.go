@@ -0,0 +1,16 @@
+package main
+
+import "testing"
+
+func TestRedEntitlementsExtendFree(t *testing.T) {
+	free, red := tierEntitlements[tierFree], tierEntitlements[tierRed]
+	if free.Tier != tierFree || red.Tier != tierRed {
+		t.Fatalf("tier names out of sync")
+	}
+	if red.MaxChirpLength < free.MaxChirpLength || red.ChirpsPerHour < free.ChirpsPerHour || red.ScheduledChirps < free.ScheduledChirps {
+		t.Fatalf("chirpy red must not lower any limit")
+	}
+	if free.MaxChirpLength != 140 {
+		t.Fatalf("free chirps must keep the classic 140 character limit")
+	}
+}
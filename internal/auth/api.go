@@ -4,10 +4,7 @@ import (
 	"net/http"
 )
 
+// GetAPIKey returns the key from an "Authorization: ApiKey <key>" header.
 func GetAPIKey(headers http.Header) (string, error) {
-	token, err := GetBearerToken(headers)
-	if err != nil {
-		return "", err
-	}
-	return token, nil
+	return GetCredentials(headers, SchemeAPIKey)
 }
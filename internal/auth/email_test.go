@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestEmailVerificationToken(t *testing.T) {
+	userID := uuid.New()
+	token, err := MakeEmailVerificationToken(userID, "lane@example.com", "tokensecret", time.Hour)
+	if err != nil {
+		t.Fatalf("error creating verification token")
+	}
+	gotID, gotEmail, err := ValidateEmailVerificationToken(token, "tokensecret")
+	if err != nil {
+		t.Fatalf("error validating a proper verification token: %v", err)
+	}
+	if gotID != userID || gotEmail != "lane@example.com" {
+		t.Fatalf("wrong user or email retrieved from verification token")
+	}
+}
+
+func TestVerificationTokenNotAccessToken(t *testing.T) {
+	token, err := MakeEmailVerificationToken(uuid.New(), "lane@example.com", "tokensecret", time.Hour)
+	if err != nil {
+		t.Fatalf("error creating verification token")
+	}
+	_, err = ValidateJWT(token, "tokensecret")
+	if err == nil {
+		t.Fatalf("verification token accepted as access token")
+	}
+}
+
+func TestAccessTokenNotVerificationToken(t *testing.T) {
+	token, err := MakeJWT(uuid.New(), "tokensecret", time.Hour)
+	if err != nil {
+		t.Fatalf("error creating token with MakeJWT")
+	}
+	_, _, err = ValidateEmailVerificationToken(token, "tokensecret")
+	if err == nil {
+		t.Fatalf("access token accepted as verification token")
+	}
+}
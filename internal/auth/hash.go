@@ -1,25 +1,223 @@
 package auth
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
 	"log"
+	"strings"
+	"sync"
 
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
 )
 
+var ErrUnknownHashFormat = errors.New("unknown password hash format")
+
+// Hasher is one password hashing algorithm. Stored hashes are self-describing
+// so any registered hasher can verify them regardless of which one is current.
+type Hasher interface {
+	Hash(password string) (string, error)
+	Verify(hash, password string) error
+	// Recognizes reports whether hash was produced by this algorithm.
+	Recognizes(hash string) bool
+	// Outdated reports whether a recognized hash used weaker parameters than
+	// the hasher is configured with.
+	Outdated(hash string) bool
+}
+
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h BcryptHasher) Hash(password string) (string, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hashedPassword), nil
+}
+
+func (h BcryptHasher) Verify(hash, password string) error {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+}
+
+func (h BcryptHasher) Recognizes(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+func (h BcryptHasher) Outdated(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err == nil && cost < h.Cost
+}
+
+// Argon2idHasher produces PHC strings such as
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>.
+type Argon2idHasher struct {
+	// memory in KiB
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2idHasher follows the OWASP baseline recommendation.
+var DefaultArgon2idHasher = Argon2idHasher{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+func (h Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.Iterations, h.Memory, h.Parallelism, h.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Memory, h.Iterations, h.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+type argon2idHash struct {
+	params Argon2idHasher
+	salt   []byte
+	key    []byte
+}
+
+func parseArgon2idHash(hash string) (argon2idHash, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return argon2idHash{}, ErrUnknownHashFormat
+	}
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil {
+		return argon2idHash{}, err
+	}
+	if version != argon2.Version {
+		return argon2idHash{}, fmt.Errorf("unsupported argon2 version %d", version)
+	}
+	parsed := argon2idHash{}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &parsed.params.Memory, &parsed.params.Iterations, &parsed.params.Parallelism)
+	if err != nil {
+		return argon2idHash{}, err
+	}
+	parsed.salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return argon2idHash{}, err
+	}
+	parsed.key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return argon2idHash{}, err
+	}
+	parsed.params.SaltLength = uint32(len(parsed.salt))
+	parsed.params.KeyLength = uint32(len(parsed.key))
+	return parsed, nil
+}
+
+func (h Argon2idHasher) Verify(hash, password string) error {
+	parsed, err := parseArgon2idHash(hash)
+	if err != nil {
+		return err
+	}
+	p := parsed.params
+	key := argon2.IDKey([]byte(password), parsed.salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	if subtle.ConstantTimeCompare(key, parsed.key) != 1 {
+		return errors.New("password does not match hash")
+	}
+	return nil
+}
+
+func (h Argon2idHasher) Recognizes(hash string) bool {
+	return strings.HasPrefix(hash, "$argon2id$")
+}
+
+func (h Argon2idHasher) Outdated(hash string) bool {
+	parsed, err := parseArgon2idHash(hash)
+	if err != nil {
+		return false
+	}
+	p := parsed.params
+	return p.Memory < h.Memory || p.Iterations < h.Iterations || p.Parallelism < h.Parallelism ||
+		p.SaltLength < h.SaltLength || p.KeyLength < h.KeyLength
+}
+
+var (
+	currentHasher Hasher = DefaultArgon2idHasher
+	// hashers able to verify stored hashes; the current one is always tried too
+	legacyHashers = []Hasher{BcryptHasher{Cost: bcrypt.DefaultCost}, DefaultArgon2idHasher}
+)
+
+// SetHasher changes the algorithm used for new hashes. Hashes made by any
+// other algorithm, or with weaker settings, are reported by NeedsRehash.
+func SetHasher(h Hasher) {
+	currentHasher = h
+}
+
+func hasherFor(hash string) (Hasher, error) {
+	if currentHasher.Recognizes(hash) {
+		return currentHasher, nil
+	}
+	for _, h := range legacyHashers {
+		if h.Recognizes(hash) {
+			return h, nil
+		}
+	}
+	return nil, ErrUnknownHashFormat
+}
+
 func HashPassword(password string) (string, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hashedPassword, err := currentHasher.Hash(password)
 	if err != nil {
 		log.Printf("error creating hashed password\n")
 		return password, err
 	}
-	return string(hashedPassword), nil
+	return hashedPassword, nil
 }
 
 func CheckPasswordHash(hash, password string) error {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	h, err := hasherFor(hash)
+	if err != nil {
+		log.Printf("unrecognized password hash")
+		return err
+	}
+	err = h.Verify(hash, password)
 	if err != nil {
 		log.Printf("password incorrect")
 		return err
 	}
 	return nil
 }
+
+// NeedsRehash reports whether hash should be replaced with a fresh one from
+// the current hasher, either because it uses a different algorithm or
+// weaker parameters.
+func NeedsRehash(hash string) bool {
+	if !currentHasher.Recognizes(hash) {
+		return true
+	}
+	return currentHasher.Outdated(hash)
+}
+
+var (
+	dummyHash     string
+	dummyHashOnce sync.Once
+)
+
+// CheckDummyPasswordHash spends the same time as a real password check so
+// logins for unknown emails can't be told apart by response time.
+func CheckDummyPasswordHash(password string) {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = currentHasher.Hash("chirpy-dummy-password")
+	})
+	currentHasher.Verify(dummyHash, password)
+}
@@ -1,6 +1,9 @@
 package auth
 
-import "testing"
+import (
+	"strings"
+	"testing"
+)
 
 func TestEnterCorrectPassword(t *testing.T) {
 	password := "WhatInTheFuck"
@@ -27,3 +30,55 @@ func TestEnterIncorrectPassword(t *testing.T) {
 		t.Fatalf("incorrect password passed hash check")
 	}
 }
+
+func TestNeedsRehashAfterCostIncrease(t *testing.T) {
+	defer SetHasher(currentHasher)
+	SetHasher(BcryptHasher{Cost: 4})
+	hashedPassword, err := HashPassword("WhatInTheFuck")
+	if err != nil {
+		t.Fatalf("error creating hashed password")
+	}
+	if NeedsRehash(hashedPassword) {
+		t.Fatalf("fresh hash reported as needing rehash")
+	}
+	SetHasher(BcryptHasher{Cost: 5})
+	if !NeedsRehash(hashedPassword) {
+		t.Fatalf("hash with old cost not reported as needing rehash")
+	}
+}
+
+func TestArgon2idHashFormat(t *testing.T) {
+	hashedPassword, err := DefaultArgon2idHasher.Hash("WhatInTheFuck")
+	if err != nil {
+		t.Fatalf("error creating hashed password")
+	}
+	if !strings.HasPrefix(hashedPassword, "$argon2id$v=19$m=65536,t=3,p=2$") {
+		t.Fatalf("unexpected PHC string %s", hashedPassword)
+	}
+	if DefaultArgon2idHasher.Verify(hashedPassword, "WhatInTheFuck") != nil {
+		t.Fatalf("error verifying argon2id hash")
+	}
+	if DefaultArgon2idHasher.Verify(hashedPassword, "WhatInTheFuck2") == nil {
+		t.Fatalf("incorrect password passed argon2id check")
+	}
+	stronger := DefaultArgon2idHasher
+	stronger.Iterations++
+	if !stronger.Outdated(hashedPassword) {
+		t.Fatalf("hash with fewer iterations not reported as outdated")
+	}
+}
+
+func TestLegacyBcryptMigratesToArgon2id(t *testing.T) {
+	defer SetHasher(currentHasher)
+	legacyHash, err := BcryptHasher{Cost: 4}.Hash("WhatInTheFuck")
+	if err != nil {
+		t.Fatalf("error creating bcrypt hash")
+	}
+	SetHasher(DefaultArgon2idHasher)
+	if CheckPasswordHash(legacyHash, "WhatInTheFuck") != nil {
+		t.Fatalf("legacy bcrypt hash no longer verifies")
+	}
+	if !NeedsRehash(legacyHash) {
+		t.Fatalf("legacy bcrypt hash not flagged for rehash")
+	}
+}
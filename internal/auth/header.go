@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Authentication schemes accepted in the Authorization header. Scheme names
+// are compared case-insensitively, as RFC 7235 requires.
+const (
+	SchemeBearer = "Bearer"
+	// used by Polka when calling our webhooks
+	SchemeAPIKey = "ApiKey"
+)
+
+var (
+	ErrNoAuthHeader        = errors.New("no authorization header")
+	ErrMalformedAuthHeader = errors.New("malformed authorization header")
+)
+
+// SchemeError reports credentials sent under a scheme other than the one
+// the endpoint expects.
+type SchemeError struct {
+	Want string
+	Got  string
+}
+
+func (e *SchemeError) Error() string {
+	return fmt.Sprintf("expected %s credentials, got %s", e.Want, e.Got)
+}
+
+// ParseAuthorization splits an Authorization header value into its scheme
+// and credentials (RFC 7235 section 2.1). Only the token68 form is
+// accepted since none of our schemes use auth-params.
+func ParseAuthorization(header string) (scheme, credentials string, err error) {
+	if header == "" {
+		return "", "", ErrNoAuthHeader
+	}
+	scheme, rest, found := strings.Cut(header, " ")
+	if !isToken(scheme) {
+		return "", "", fmt.Errorf("%w: invalid scheme", ErrMalformedAuthHeader)
+	}
+	credentials = strings.Trim(rest, " ")
+	if !found || credentials == "" {
+		return "", "", fmt.Errorf("%w: missing credentials", ErrMalformedAuthHeader)
+	}
+	if !isToken68(credentials) {
+		return "", "", fmt.Errorf("%w: invalid credentials", ErrMalformedAuthHeader)
+	}
+	return scheme, credentials, nil
+}
+
+// GetCredentials returns the credentials from the Authorization header,
+// which must use the given scheme.
+func GetCredentials(headers http.Header, scheme string) (string, error) {
+	values := headers.Values("Authorization")
+	if len(values) == 0 {
+		return "", ErrNoAuthHeader
+	}
+	if len(values) > 1 {
+		return "", fmt.Errorf("%w: multiple authorization headers", ErrMalformedAuthHeader)
+	}
+	got, credentials, err := ParseAuthorization(values[0])
+	if err != nil {
+		return "", err
+	}
+	if !strings.EqualFold(got, scheme) {
+		return "", &SchemeError{Want: scheme, Got: got}
+	}
+	return credentials, nil
+}
+
+func GetBearerToken(headers http.Header) (string, error) {
+	return GetCredentials(headers, SchemeBearer)
+}
+
+// isToken reports whether s is a non-empty RFC 7230 token.
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if isAlphaNum(c) || strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0 {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// isToken68 reports whether s matches
+// 1*( ALPHA / DIGIT / "-" / "." / "_" / "~" / "+" / "/" ) *"=".
+func isToken68(s string) bool {
+	body := strings.TrimRight(s, "=")
+	if body == "" {
+		return false
+	}
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+		if isAlphaNum(c) || strings.IndexByte("-._~+/", c) >= 0 {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+func isAlphaNum(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
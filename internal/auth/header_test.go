@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestParseAuthorization(t *testing.T) {
+	cases := []struct {
+		header      string
+		scheme      string
+		credentials string
+		err         error
+	}{
+		{header: "Bearer abc.def-ghi_jkl", scheme: "Bearer", credentials: "abc.def-ghi_jkl"},
+		{header: "bearer   abc", scheme: "bearer", credentials: "abc"},
+		{header: "ApiKey f271c81ff7084ee5b99a5091b42d486e", scheme: "ApiKey", credentials: "f271c81ff7084ee5b99a5091b42d486e"},
+		{header: "Basic dXNlcjpwYXNz==", scheme: "Basic", credentials: "dXNlcjpwYXNz=="},
+		{header: "", err: ErrNoAuthHeader},
+		{header: "Bearer", err: ErrMalformedAuthHeader},
+		{header: "Bearer ", err: ErrMalformedAuthHeader},
+		{header: "abc", err: ErrMalformedAuthHeader},
+		{header: " Bearer abc", err: ErrMalformedAuthHeader},
+		{header: "Bearer abc def", err: ErrMalformedAuthHeader},
+		{header: "Bearer ==", err: ErrMalformedAuthHeader},
+		{header: "Bearer a=b", err: ErrMalformedAuthHeader},
+		{header: "Bea(rer abc", err: ErrMalformedAuthHeader},
+		{header: "Bearer\tabc", err: ErrMalformedAuthHeader},
+	}
+	for _, c := range cases {
+		scheme, credentials, err := ParseAuthorization(c.header)
+		if !errors.Is(err, c.err) {
+			t.Errorf("%q: got error %v, want %v", c.header, err, c.err)
+			continue
+		}
+		if scheme != c.scheme || credentials != c.credentials {
+			t.Errorf("%q: got %q %q", c.header, scheme, credentials)
+		}
+	}
+}
+
+func TestGetCredentialsScheme(t *testing.T) {
+	header := http.Header{}
+	header.Set("Authorization", "ApiKey secret")
+	_, err := GetBearerToken(header)
+	var schemeErr *SchemeError
+	if !errors.As(err, &schemeErr) || schemeErr.Got != "ApiKey" {
+		t.Fatalf("api key accepted as bearer token")
+	}
+	key, err := GetAPIKey(header)
+	if err != nil || key != "secret" {
+		t.Fatalf("failed getting api key from header")
+	}
+	header.Set("Authorization", "APIKEY secret")
+	if _, err := GetAPIKey(header); err != nil {
+		t.Fatalf("scheme should be case-insensitive")
+	}
+	header.Add("Authorization", "ApiKey other")
+	if _, err := GetAPIKey(header); !errors.Is(err, ErrMalformedAuthHeader) {
+		t.Fatalf("multiple authorization headers accepted")
+	}
+	if _, err := GetAPIKey(http.Header{}); !errors.Is(err, ErrNoAuthHeader) {
+		t.Fatalf("missing header not reported")
+	}
+}
+
+func FuzzParseAuthorization(f *testing.F) {
+	for _, seed := range []string{"", "Bearer", "Bearer ", "Bearer abc", "ApiKey  key==", "a b c", "Basic ===", "\x00 \x00"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, header string) {
+		scheme, credentials, err := ParseAuthorization(header)
+		if err != nil {
+			if !errors.Is(err, ErrNoAuthHeader) && !errors.Is(err, ErrMalformedAuthHeader) {
+				t.Fatalf("untyped error %v", err)
+			}
+			return
+		}
+		if !isToken(scheme) || !isToken68(credentials) {
+			t.Fatalf("accepted invalid credentials %q %q", scheme, credentials)
+		}
+		// the canonical form must parse back to the same values
+		again, credentialsAgain, err := ParseAuthorization(scheme + " " + credentials)
+		if err != nil || again != scheme || credentialsAgain != credentials {
+			t.Fatalf("round trip of %q failed", header)
+		}
+	})
+}
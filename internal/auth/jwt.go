@@ -2,20 +2,67 @@ package auth
 
 import (
 	"errors"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 )
 
+type accessTokenClaims struct {
+	// space-delimited, as in RFC 6749; only set on tokens issued to OAuth clients
+	Scope    string `json:"scope,omitempty"`
+	ClientID string `json:"client_id,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// AccessClaims describes who an access token acts for. First-party tokens
+// from MakeJWT have no client or key and may do anything the user can.
+type AccessClaims struct {
+	UserID uuid.UUID
+	// the JWT ID, or the key ID for personal access tokens
+	TokenID  string
+	ClientID string
+	// set when the token is a personal access token
+	APIKeyID uuid.UUID
+	Scopes   []string
+}
+
+func (c AccessClaims) Delegated() bool {
+	return c.ClientID != "" || c.APIKeyID != uuid.Nil
+}
+
+func (c AccessClaims) HasScope(scope string) bool {
+	return !c.Delegated() || slices.Contains(c.Scopes, scope)
+}
+
 func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
+	return makeAccessToken(userID, "", nil, tokenSecret, expiresIn)
+}
+
+// MakeScopedJWT issues an access token to a third-party OAuth client, limited
+// to the scopes the user consented to.
+func MakeScopedJWT(userID uuid.UUID, clientID string, scopes []string, tokenSecret string, expiresIn time.Duration) (string, error) {
+	if clientID == "" {
+		return "", errors.New("scoped tokens require a client id")
+	}
+	return makeAccessToken(userID, clientID, scopes, tokenSecret, expiresIn)
+}
+
+func makeAccessToken(userID uuid.UUID, clientID string, scopes []string, tokenSecret string, expiresIn time.Duration) (string, error) {
 
-	claims := jwt.RegisteredClaims{
+	claims := accessTokenClaims{
+		Scope:    strings.Join(scopes, " "),
+		ClientID: clientID,
+		RegisteredClaims: jwt.RegisteredClaims{
 
-		Issuer:    "chirpy",
-		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
-		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
-		Subject:   userID.String(),
+			Issuer:    "chirpy",
+			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
+			Subject:   userID.String(),
+			ID:        uuid.NewString(),
+		},
 	}
 	newToken := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	signedToken, err := newToken.SignedString([]byte(tokenSecret))
@@ -25,30 +72,56 @@ func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (str
 	return signedToken, nil
 }
 
+// ValidateJWT accepts only first-party access tokens. Handlers that third
+// party clients may call use ValidateAccessToken and check scopes instead.
 func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
-	claimsStruct := jwt.RegisteredClaims{}
+	claims, err := ValidateAccessToken(tokenString, tokenSecret)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if claims.Delegated() {
+		return uuid.Nil, errors.New("token was issued to a third-party client")
+	}
+	return claims.UserID, nil
+}
+
+func ValidateAccessToken(tokenString, tokenSecret string) (AccessClaims, error) {
+	claimsStruct := accessTokenClaims{}
 	token, err := jwt.ParseWithClaims(
 		tokenString,
 		&claimsStruct,
 		func(token *jwt.Token) (interface{}, error) { return []byte(tokenSecret), nil },
 	)
 	if err != nil {
-		return uuid.Nil, err
+		return AccessClaims{}, err
 	}
 	userIDString, err := token.Claims.GetSubject()
 	if err != nil {
-		return uuid.Nil, err
+		return AccessClaims{}, err
 	}
 	issuer, err := token.Claims.GetIssuer()
 	if err != nil {
-		return uuid.Nil, err
+		return AccessClaims{}, err
 	}
 	if issuer != "chirpy" {
-		return uuid.Nil, errors.New("invalid issuer")
+		return AccessClaims{}, errors.New("invalid issuer")
+	}
+	// access tokens carry no audience; anything with one was minted for another purpose
+	audience, err := token.Claims.GetAudience()
+	if err != nil {
+		return AccessClaims{}, err
+	}
+	if len(audience) != 0 {
+		return AccessClaims{}, errors.New("token is not an access token")
 	}
 	id, err := uuid.Parse(userIDString)
 	if err != nil {
-		return uuid.Nil, err
+		return AccessClaims{}, err
 	}
-	return id, nil
+	return AccessClaims{
+		UserID:   id,
+		TokenID:  claimsStruct.ID,
+		ClientID: claimsStruct.ClientID,
+		Scopes:   strings.Fields(claimsStruct.Scope),
+	}, nil
 }
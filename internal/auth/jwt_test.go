@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"context"
 	"net/http"
 	"testing"
 	"time"
@@ -43,3 +44,28 @@ func TestGetBearerToken(t *testing.T) {
 		t.Fatalf("wrong token retrieved from header")
 	}
 }
+
+func TestAccessTokenID(t *testing.T) {
+	userID := uuid.New()
+	first, _ := MakeJWT(userID, "tokensecret", time.Hour)
+	second, _ := MakeJWT(userID, "tokensecret", time.Hour)
+	firstClaims, err := ValidateAccessToken(first, "tokensecret")
+	if err != nil {
+		t.Fatalf("error validating a proper token")
+	}
+	secondClaims, _ := ValidateAccessToken(second, "tokensecret")
+	if firstClaims.TokenID == "" || firstClaims.TokenID == secondClaims.TokenID {
+		t.Fatalf("access tokens need unique ids")
+	}
+}
+
+func TestPrincipalFromContext(t *testing.T) {
+	if _, ok := PrincipalFromContext(context.Background()); ok {
+		t.Fatalf("anonymous context has a principal")
+	}
+	principal := Principal{AccessClaims: AccessClaims{UserID: uuid.New()}, Roles: []string{RoleAdmin}}
+	got, ok := PrincipalFromContext(WithPrincipal(context.Background(), principal))
+	if !ok || got.UserID != principal.UserID || !got.HasRole(RoleAdmin) {
+		t.Fatalf("principal not stored in context")
+	}
+}
@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RFC 7636 appendix B
+func TestVerifyPKCE(t *testing.T) {
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+	if !VerifyPKCE(verifier, challenge) {
+		t.Fatalf("valid code verifier rejected")
+	}
+	if VerifyPKCE(verifier+"x", challenge) {
+		t.Fatalf("wrong code verifier accepted")
+	}
+}
+
+func TestParseScopes(t *testing.T) {
+	scopes, err := ParseScopes("chirps:read chirps:read chirps:write", KnownScopes)
+	if err != nil || len(scopes) != 2 {
+		t.Fatalf("error parsing known scopes")
+	}
+	if _, err := ParseScopes("chirps:write admin", KnownScopes); err == nil {
+		t.Fatalf("unknown scope accepted")
+	}
+}
+
+func TestScopedJWT(t *testing.T) {
+	userID := uuid.New()
+	token, err := MakeScopedJWT(userID, "client", []string{ScopeChirpsRead}, "tokensecret", time.Hour)
+	if err != nil {
+		t.Fatalf("error creating scoped token")
+	}
+	claims, err := ValidateAccessToken(token, "tokensecret")
+	if err != nil {
+		t.Fatalf("error validating scoped token")
+	}
+	if claims.UserID != userID || !claims.HasScope(ScopeChirpsRead) || claims.HasScope(ScopeChirpsWrite) {
+		t.Fatalf("wrong claims retrieved from scoped token")
+	}
+	if _, err := ValidateJWT(token, "tokensecret"); err == nil {
+		t.Fatalf("scoped token accepted as first-party token")
+	}
+}
+
+func TestOIDCStateRoundTrip(t *testing.T) {
+	state, err := NewOIDCState()
+	if err != nil {
+		t.Fatalf("error creating oidc state")
+	}
+	if !VerifyPKCE(state.CodeVerifier, state.CodeChallenge()) {
+		t.Fatalf("oidc code challenge does not match verifier")
+	}
+	sealed, err := SealOIDCState(state, "tokensecret", time.Minute)
+	if err != nil {
+		t.Fatalf("error sealing oidc state")
+	}
+	opened, err := OpenOIDCState(sealed, "tokensecret")
+	if err != nil || opened != state {
+		t.Fatalf("oidc state did not survive round trip")
+	}
+	if _, err := ValidateJWT(sealed, "tokensecret"); err == nil {
+		t.Fatalf("oidc state accepted as access token")
+	}
+}
@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const oidcStateAudience = "chirpy-oidc-state"
+
+// OIDCState is what Chirpy needs to remember between redirecting to an
+// external provider and handling its callback.
+type OIDCState struct {
+	State        string `json:"state"`
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"code_verifier"`
+}
+
+type oidcStateClaims struct {
+	OIDCState
+	jwt.RegisteredClaims
+}
+
+func randomURLString() (string, error) {
+	byteSlice := make([]byte, 32)
+	_, err := rand.Read(byteSlice)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(byteSlice), nil
+}
+
+// NewOIDCState generates a fresh state, nonce and PKCE verifier.
+func NewOIDCState() (OIDCState, error) {
+	s := OIDCState{}
+	var err error
+	if s.State, err = randomURLString(); err != nil {
+		return OIDCState{}, err
+	}
+	if s.Nonce, err = randomURLString(); err != nil {
+		return OIDCState{}, err
+	}
+	if s.CodeVerifier, err = randomURLString(); err != nil {
+		return OIDCState{}, err
+	}
+	return s, nil
+}
+
+// CodeChallenge returns the S256 PKCE challenge for the state's verifier.
+func (s OIDCState) CodeChallenge() string {
+	sum := sha256.Sum256([]byte(s.CodeVerifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// SealOIDCState signs s so it can be stored in a cookie on the browser.
+func SealOIDCState(s OIDCState, tokenSecret string, expiresIn time.Duration) (string, error) {
+	claims := oidcStateClaims{
+		OIDCState: s,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "chirpy",
+			Audience:  jwt.ClaimStrings{oidcStateAudience},
+			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
+		},
+	}
+	newToken := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	signedToken, err := newToken.SignedString([]byte(tokenSecret))
+	if err != nil {
+		return "", err
+	}
+	return signedToken, nil
+}
+
+func OpenOIDCState(sealed, tokenSecret string) (OIDCState, error) {
+	claims := oidcStateClaims{}
+	_, err := jwt.ParseWithClaims(
+		sealed,
+		&claims,
+		func(token *jwt.Token) (interface{}, error) { return []byte(tokenSecret), nil },
+		jwt.WithIssuer("chirpy"),
+		jwt.WithAudience(oidcStateAudience),
+	)
+	if err != nil {
+		return OIDCState{}, err
+	}
+	if claims.State == "" || claims.Nonce == "" || claims.CodeVerifier == "" {
+		return OIDCState{}, errors.New("incomplete oidc state")
+	}
+	return claims.OIDCState, nil
+}
@@ -0,0 +1,148 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// bcrypt ignores everything past 72 bytes, so while it hashes new passwords
+// longer ones would give a false sense of security. Argon2id has no such
+// limit.
+const MaxPasswordBytes = 72
+
+var (
+	ErrPasswordTooShort = errors.New("password is too short")
+	ErrPasswordTooLong  = fmt.Errorf("password is longer than %d bytes", MaxPasswordBytes)
+	ErrPasswordTooWeak  = errors.New("password is too easy to guess")
+	ErrPasswordBreached = errors.New("password has appeared in a data breach")
+)
+
+type PasswordPolicy struct {
+	MinLength      int
+	MinEntropyBits float64
+	// optional; nil skips the breach check
+	Breached *BreachedPasswords
+}
+
+// Validate returns one of the ErrPassword* errors if password breaks the
+// policy. The messages are safe to show to users.
+func (p PasswordPolicy) Validate(password string) error {
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return ErrPasswordTooShort
+	}
+	if _, bcrypt := currentHasher.(BcryptHasher); bcrypt && len(password) > MaxPasswordBytes {
+		return ErrPasswordTooLong
+	}
+	if EstimateEntropy(password) < p.MinEntropyBits {
+		return ErrPasswordTooWeak
+	}
+	if p.Breached != nil && p.Breached.Contains(password) {
+		return ErrPasswordBreached
+	}
+	return nil
+}
+
+// EstimateEntropy gives a rough upper bound in bits: the size of the character
+// classes used, raised to the number of distinct characters. Counting distinct
+// characters keeps "aaaaaaaaaaaa" from scoring like a random string.
+func EstimateEntropy(password string) float64 {
+	var lower, upper, digit, symbol, other bool
+	distinct := make(map[rune]bool)
+	for _, c := range password {
+		distinct[c] = true
+		switch {
+		case c > unicode.MaxASCII:
+			other = true
+		case unicode.IsLower(c):
+			lower = true
+		case unicode.IsUpper(c):
+			upper = true
+		case unicode.IsDigit(c):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	pool := 0
+	if lower {
+		pool += 26
+	}
+	if upper {
+		pool += 26
+	}
+	if digit {
+		pool += 10
+	}
+	if symbol {
+		pool += 33
+	}
+	if other {
+		pool += 100
+	}
+	if pool == 0 {
+		return 0
+	}
+	length := min(utf8.RuneCountInString(password), 2*len(distinct))
+	return float64(length) * math.Log2(float64(pool))
+}
+
+// BreachedPasswords is a local copy of a breached-password corpus, indexed the
+// same way as the k-anonymity range API: by the first five hex characters of
+// the SHA-1 hash, then by the remaining 35.
+type BreachedPasswords struct {
+	ranges map[string]map[string]struct{}
+}
+
+// LoadBreachedPasswords reads a file of uppercase or lowercase SHA-1 hashes,
+// one per line, each optionally followed by ":count" as in the downloadable
+// pwned-passwords list. Blank lines and lines starting with # are skipped.
+func LoadBreachedPasswords(path string) (*BreachedPasswords, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	list := &BreachedPasswords{ranges: make(map[string]map[string]struct{})}
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		hash, _, _ := strings.Cut(line, ":")
+		hash = strings.ToUpper(hash)
+		if len(hash) != sha1.Size*2 {
+			return nil, fmt.Errorf("%s:%d: expected a SHA-1 hash", path, lineNumber)
+		}
+		if _, err := hex.DecodeString(hash); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, lineNumber, err)
+		}
+		prefix, suffix := hash[:5], hash[5:]
+		if list.ranges[prefix] == nil {
+			list.ranges[prefix] = make(map[string]struct{})
+		}
+		list.ranges[prefix][suffix] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (b *BreachedPasswords) Contains(password string) bool {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	_, ok := b.ranges[hash[:5]][hash[5:]]
+	return ok
+}
@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	policy := PasswordPolicy{MinLength: 8, MinEntropyBits: 40}
+	cases := map[string]error{
+		"":                         ErrPasswordTooShort,
+		"Ab1!":                     ErrPasswordTooShort,
+		"aaaaaaaaaaaaaaaa":         ErrPasswordTooWeak,
+		"correct horse battery":    nil,
+		strings.Repeat("Ab1!", 19): nil,
+	}
+	for password, expected := range cases {
+		err := policy.Validate(password)
+		if !errors.Is(err, expected) {
+			t.Errorf("password %q: expected %v but got %v", password, expected, err)
+		}
+	}
+}
+
+func TestPasswordLengthCapOnlyForBcrypt(t *testing.T) {
+	defer SetHasher(currentHasher)
+	policy := PasswordPolicy{MinLength: 8}
+	long := strings.Repeat("Ab1!", 19)
+	SetHasher(DefaultArgon2idHasher)
+	if err := policy.Validate(long); err != nil {
+		t.Fatalf("argon2id rejected a %d byte password: %v", len(long), err)
+	}
+	SetHasher(BcryptHasher{Cost: 4})
+	if err := policy.Validate(long); !errors.Is(err, ErrPasswordTooLong) {
+		t.Fatalf("bcrypt accepted a %d byte password: %v", len(long), err)
+	}
+}
+
+func TestBreachedPasswords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	// SHA-1 of "password" followed by a count, as in the pwned-passwords download
+	contents := "# test list\n5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8:3730471\n"
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatalf("error writing test list")
+	}
+	list, err := LoadBreachedPasswords(path)
+	if err != nil {
+		t.Fatalf("error loading breached passwords: %v", err)
+	}
+	if !list.Contains("password") {
+		t.Fatalf("breached password not found")
+	}
+	if list.Contains("WhatInTheFuck") {
+		t.Fatalf("unbreached password reported as breached")
+	}
+	policy := PasswordPolicy{MinLength: 1, Breached: list}
+	if !errors.Is(policy.Validate("password"), ErrPasswordBreached) {
+		t.Fatalf("policy accepted breached password")
+	}
+}
@@ -0,0 +1,26 @@
+package auth
+
+import "strings"
+
+// Personal access tokens are opaque rather than JWTs so they can be revoked.
+// The prefix lets the bearer path tell them apart without a database lookup
+// and makes leaked tokens easy to spot in secret scanners.
+const personalAccessTokenPrefix = "chirpy_pat_"
+
+func MakePersonalAccessToken() (string, error) {
+	token, err := MakeOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	return personalAccessTokenPrefix + token, nil
+}
+
+func IsPersonalAccessToken(token string) bool {
+	return strings.HasPrefix(token, personalAccessTokenPrefix)
+}
+
+// PersonalAccessTokenHint returns the part of a token that is safe to show
+// when listing keys.
+func PersonalAccessTokenHint(token string) string {
+	return token[:min(len(token), len(personalAccessTokenPrefix)+6)]
+}
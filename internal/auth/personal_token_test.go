@@ -0,0 +1,25 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestPersonalAccessToken(t *testing.T) {
+	token, err := MakePersonalAccessToken()
+	if err != nil {
+		t.Fatalf("error creating personal access token")
+	}
+	if !IsPersonalAccessToken(token) {
+		t.Fatalf("personal access token not recognised")
+	}
+	jwtToken, _ := MakeJWT(uuid.New(), "tokensecret", time.Hour)
+	if IsPersonalAccessToken(jwtToken) {
+		t.Fatalf("jwt recognised as personal access token")
+	}
+	if hint := PersonalAccessTokenHint(token); hint != token[:17] {
+		t.Fatalf("unexpected token hint %s", hint)
+	}
+}
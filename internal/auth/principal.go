@@ -0,0 +1,31 @@
+package auth
+
+import (
+	"context"
+	"slices"
+)
+
+const RoleAdmin = "admin"
+
+// Principal is the authenticated caller of a request, stored in the request
+// context by the server's auth middleware.
+type Principal struct {
+	AccessClaims
+	Roles []string
+}
+
+func (p Principal) HasRole(role string) bool {
+	return slices.Contains(p.Roles, role)
+}
+
+type principalKey struct{}
+
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFromContext returns the caller, or false for anonymous requests.
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
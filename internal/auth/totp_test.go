@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RFC 6238 appendix B test vectors for SHA1, truncated to 6 digits.
+func TestTOTPCodeRFCVectors(t *testing.T) {
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	cases := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+	for unix, expected := range cases {
+		code, err := TOTPCode(secret, TOTPStep(time.Unix(unix, 0)))
+		if err != nil {
+			t.Fatalf("error computing totp code: %v", err)
+		}
+		if code != expected {
+			t.Errorf("at %d expected %s but got %s", unix, expected, code)
+		}
+	}
+}
+
+func TestValidateTOTPSkew(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("error generating secret")
+	}
+	now := time.Now()
+	previous, _ := TOTPCode(secret, TOTPStep(now)-1)
+	step, err := ValidateTOTP(secret, previous, now)
+	if err != nil {
+		t.Fatalf("code from previous step rejected")
+	}
+	if step != TOTPStep(now)-1 {
+		t.Fatalf("wrong step returned for matched code")
+	}
+	stale, _ := TOTPCode(secret, TOTPStep(now)-3)
+	if _, err := ValidateTOTP(secret, stale, now); err == nil {
+		t.Fatalf("stale code accepted")
+	}
+}
+
+func TestRecoveryCodeHashNormalised(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	if err != nil || len(codes) != 10 {
+		t.Fatalf("error generating recovery codes")
+	}
+	if HashRecoveryCode(codes[0]) != HashRecoveryCode(" "+codes[0][:5]+codes[0][6:]) {
+		t.Fatalf("recovery code hash not normalised")
+	}
+}
+
+func TestChallengeTokenNotAccessToken(t *testing.T) {
+	userID := uuid.New()
+	token, err := MakeTwoFactorChallengeToken(userID, "tokensecret", time.Minute)
+	if err != nil {
+		t.Fatalf("error creating challenge token")
+	}
+	if _, err := ValidateJWT(token, "tokensecret"); err == nil {
+		t.Fatalf("challenge token accepted as access token")
+	}
+	gotID, err := ValidateTwoFactorChallengeToken(token, "tokensecret")
+	if err != nil || gotID != userID {
+		t.Fatalf("error validating challenge token")
+	}
+}
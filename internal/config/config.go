@@ -0,0 +1,355 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is every setting chirpy reads at startup. Each field is loaded from
+// the variable named by its env tag, with the default tag used when no
+// source sets it. Fields tagged secret can instead be read from the file
+// named by <VAR>_FILE and are redacted by Print.
+type Config struct {
+	Addr     string `env:"ADDR" default:":8080"`
+	Platform string `env:"PLATFORM"`
+	BaseURL  string `env:"BASE_URL" default:"http://localhost:8080"`
+	// only the password is redacted so the host stays visible
+	DBURL  string `env:"DB_URL" required:"true" secret:"password"`
+	Secret string `env:"SECRET" required:"true" secret:"true"`
+	// apply pending migrations on boot instead of with "chirpy migrate up"
+	AutoMigrate bool `env:"AUTO_MIGRATE"`
+
+	DBMaxOpenConns int `env:"DB_MAX_OPEN_CONNS" default:"25"`
+	// connections the pool keeps open even when idle
+	DBMinConns        int           `env:"DB_MIN_CONNS" default:"2"`
+	DBConnMaxLifetime time.Duration `env:"DB_CONN_MAX_LIFETIME" default:"30m"`
+	DBConnMaxIdleTime time.Duration `env:"DB_CONN_MAX_IDLE_TIME" default:"5m"`
+	// how long startup keeps retrying an unreachable database
+	DBConnectTimeout time.Duration `env:"DB_CONNECT_TIMEOUT" default:"1m"`
+	// deadline for the queries of one request
+	DBQueryTimeout time.Duration `env:"DB_QUERY_TIMEOUT" default:"10s"`
+
+	LogFormat       string `env:"LOG_FORMAT" default:"text"`
+	LogLevel        string `env:"LOG_LEVEL" default:"info"`
+	OTelServiceName string `env:"OTEL_SERVICE_NAME" default:"chirpy"`
+	OTLPEndpoint    string `env:"OTEL_EXPORTER_OTLP_ENDPOINT"`
+	OTelDisabled    bool   `env:"OTEL_SDK_DISABLED"`
+
+	PolkaWebhookSecrets []string `env:"POLKA_WEBHOOK_SECRETS" secret:"true"`
+	// deployments predating signed webhooks share the old api key with Polka
+	PolkaKey string `env:"POLKA_KEY" secret:"true"`
+
+	// actions blocked until the user has verified their email
+	UnverifiedRestrictions []string `env:"UNVERIFIED_RESTRICTIONS" default:"chirp"`
+	Mailer                 string   `env:"MAILER"`
+	SMTPHost               string   `env:"SMTP_HOST"`
+	SMTPPort               string   `env:"SMTP_PORT"`
+	SMTPUsername           string   `env:"SMTP_USERNAME"`
+	SMTPPassword           string   `env:"SMTP_PASSWORD" secret:"true"`
+	MailFrom               string   `env:"MAIL_FROM"`
+
+	PasswordMinLength      int    `env:"PASSWORD_MIN_LENGTH" default:"8"`
+	PasswordMinEntropyBits int    `env:"PASSWORD_MIN_ENTROPY_BITS" default:"40"`
+	BreachedPasswordsFile  string `env:"BREACHED_PASSWORDS_FILE"`
+	PasswordHasher         string `env:"PASSWORD_HASHER" default:"argon2id"`
+	Argon2MemoryKiB        int    `env:"ARGON2_MEMORY_KIB" default:"65536"`
+	Argon2Iterations       int    `env:"ARGON2_ITERATIONS" default:"3"`
+	Argon2Parallelism      int    `env:"ARGON2_PARALLELISM" default:"2"`
+	BcryptCost             int    `env:"BCRYPT_COST" default:"10"`
+
+	OIDCIssuer       string `env:"OIDC_ISSUER"`
+	OIDCRedirectURL  string `env:"OIDC_REDIRECT_URL"`
+	OIDCClientID     string `env:"OIDC_CLIENT_ID"`
+	OIDCClientSecret string `env:"OIDC_CLIENT_SECRET" secret:"true"`
+
+	TrustProxyHeaders          bool          `env:"TRUST_PROXY_HEADERS"`
+	SubscriptionExpiryInterval time.Duration `env:"SUBSCRIPTION_EXPIRY_INTERVAL" default:"1h"`
+	WebhookPollInterval        time.Duration `env:"WEBHOOK_POLL_INTERVAL" default:"5s"`
+
+	HTTPReadHeaderTimeout time.Duration `env:"HTTP_READ_HEADER_TIMEOUT" default:"5s"`
+	HTTPReadTimeout       time.Duration `env:"HTTP_READ_TIMEOUT" default:"15s"`
+	// has to cover the slowest handler, e.g. password hashing on signup
+	HTTPWriteTimeout   time.Duration `env:"HTTP_WRITE_TIMEOUT" default:"30s"`
+	HTTPIdleTimeout    time.Duration `env:"HTTP_IDLE_TIMEOUT" default:"2m"`
+	HTTPMaxHeaderBytes int           `env:"HTTP_MAX_HEADER_BYTES" default:"65536"`
+	ShutdownTimeout    time.Duration `env:"SHUTDOWN_TIMEOUT" default:"20s"`
+	// how long /readyz fails before the listener closes; 0 in development
+	ShutdownDrainDelay time.Duration `env:"SHUTDOWN_DRAIN_DELAY" default:"5s"`
+	ReadinessTimeout   time.Duration `env:"READINESS_TIMEOUT" default:"2s"`
+	TLSCertFile        string        `env:"TLS_CERT_FILE"`
+	TLSKeyFile         string        `env:"TLS_KEY_FILE"`
+}
+
+// source is one place settings come from, keyed by variable name.
+type source struct {
+	name   string
+	lookup func(key string) (string, bool)
+}
+
+// Load reads settings with this precedence, highest first: the process
+// environment, dotenvFile, configFile (YAML or TOML, keys are the variable
+// names in any case), then defaults. Missing dotenvFile is fine; configFile
+// is optional but must exist when given. Load only fails on values it can't
+// parse; call Validate for everything else.
+func Load(configFile, dotenvFile string) (*Config, error) {
+	sources := []source{{"environment", os.LookupEnv}}
+	dotenv, err := godotenv.Read(dotenvFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("reading %s: %w", dotenvFile, err)
+	}
+	sources = append(sources, source{dotenvFile, mapLookup(dotenv)})
+	if configFile != "" {
+		values, err := readConfigFile(configFile)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, source{configFile, mapLookup(values)})
+	}
+	lookup := func(key string) (string, string, bool) {
+		for _, s := range sources {
+			if value, ok := s.lookup(key); ok {
+				return value, s.name, true
+			}
+		}
+		return "", "", false
+	}
+
+	cfg := &Config{}
+	var errs []error
+	v := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		key := field.Tag.Get("env")
+		value, from, ok := lookup(key)
+		if field.Tag.Get("secret") != "" {
+			path, fileFrom, fileOK := lookup(key + "_FILE")
+			if fileOK && ok {
+				errs = append(errs, fmt.Errorf("%s: set in %s but %s_FILE is also set in %s", key, from, key, fileFrom))
+				continue
+			}
+			if fileOK {
+				contents, err := os.ReadFile(path)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("%s_FILE: %w", key, err))
+					continue
+				}
+				value, ok = strings.TrimRight(string(contents), "\r\n"), true
+			}
+		}
+		// as before, an empty number or duration means "use the default"
+		kind := v.Field(i).Kind()
+		if !ok || (value == "" && (kind == reflect.Int || kind == reflect.Int64)) {
+			value = field.Tag.Get("default")
+		}
+		err := setField(v.Field(i), value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return cfg, nil
+}
+
+func mapLookup(values map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		value, ok := values[key]
+		return value, ok
+	}
+}
+
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	raw := map[string]interface{}{}
+	switch ext := filepath.Ext(path); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	default:
+		return nil, fmt.Errorf("config file %s: unsupported format %q, use .yaml or .toml", path, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	known := map[string]bool{}
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		key := t.Field(i).Tag.Get("env")
+		known[key] = true
+		if t.Field(i).Tag.Get("secret") != "" {
+			known[key+"_FILE"] = true
+		}
+	}
+	values := map[string]string{}
+	for key, value := range raw {
+		name := strings.ToUpper(key)
+		if !known[name] {
+			return nil, fmt.Errorf("%s: unknown setting %q", path, key)
+		}
+		switch value := value.(type) {
+		case []interface{}:
+			items := make([]string, len(value))
+			for i, item := range value {
+				items[i] = fmt.Sprint(item)
+			}
+			values[name] = strings.Join(items, ",")
+		default:
+			values[name] = fmt.Sprint(value)
+		}
+	}
+	return values, nil
+}
+
+func setField(field reflect.Value, value string) error {
+	switch field.Interface().(type) {
+	case string:
+		field.SetString(value)
+	case bool:
+		if value == "" {
+			return nil
+		}
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", value)
+		}
+		field.SetBool(b)
+	case int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", value)
+		}
+		field.SetInt(int64(n))
+	case time.Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration like 30s or 5m", value)
+		}
+		field.SetInt(int64(d))
+	case []string:
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	default:
+		panic("config: unsupported field type " + field.Type().String())
+	}
+	return nil
+}
+
+// Validate reports every problem at once so a deployment can be fixed in one
+// go rather than one restart per mistake.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	v := reflect.ValueOf(c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if field.Tag.Get("required") == "true" {
+			check(!v.Field(i).IsZero(), "%s is required", field.Tag.Get("env"))
+		}
+	}
+
+	if c.DBURL != "" && strings.Contains(c.DBURL, "://") {
+		u, err := url.Parse(c.DBURL)
+		check(err == nil && (u.Scheme == "postgres" || u.Scheme == "postgresql"),
+			"DB_URL must be a postgres:// url")
+	}
+	// HS256 keys shorter than the hash are brute-forceable
+	check(c.Secret == "" || c.Platform == "dev" || len(c.Secret) >= 32,
+		"SECRET must be at least 32 bytes outside PLATFORM=dev")
+	u, err := url.Parse(c.BaseURL)
+	check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+		"BASE_URL must be an absolute http or https url")
+	check(slices.Contains([]string{"text", "json"}, c.LogFormat), "LOG_FORMAT must be text or json")
+	check(slices.Contains([]string{"debug", "info", "warn", "error"}, c.LogLevel), "LOG_LEVEL must be debug, info, warn or error")
+	check(slices.Contains([]string{"", "log", "smtp"}, c.Mailer), "MAILER must be log or smtp")
+	if c.Mailer == "smtp" {
+		check(c.SMTPHost != "" && c.SMTPPort != "" && c.MailFrom != "",
+			"MAILER=smtp requires SMTP_HOST, SMTP_PORT and MAIL_FROM")
+	}
+	check(slices.Contains([]string{"argon2id", "bcrypt"}, c.PasswordHasher), "PASSWORD_HASHER must be argon2id or bcrypt")
+	check(c.PasswordMinLength > 0, "PASSWORD_MIN_LENGTH must be positive")
+	check(c.Argon2MemoryKiB >= 8*c.Argon2Parallelism, "ARGON2_MEMORY_KIB must be at least 8 per thread")
+	check(c.Argon2Iterations >= 1, "ARGON2_ITERATIONS must be at least 1")
+	check(c.Argon2Parallelism >= 1 && c.Argon2Parallelism <= 255, "ARGON2_PARALLELISM must be between 1 and 255")
+	check(c.BcryptCost >= 4 && c.BcryptCost <= 31, "BCRYPT_COST must be between 4 and 31")
+	if c.OIDCIssuer != "" {
+		check(c.OIDCClientID != "", "OIDC_ISSUER requires OIDC_CLIENT_ID")
+	}
+	check((c.TLSCertFile == "") == (c.TLSKeyFile == ""), "TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	check(c.ShutdownDrainDelay >= 0, "SHUTDOWN_DRAIN_DELAY can't be negative")
+	check(c.DBMaxOpenConns > 0, "DB_MAX_OPEN_CONNS must be positive")
+	check(c.DBMinConns >= 0 && c.DBMinConns <= c.DBMaxOpenConns,
+		"DB_MIN_CONNS must be between 0 and DB_MAX_OPEN_CONNS")
+	check(c.DBConnMaxLifetime >= 0 && c.DBConnMaxIdleTime >= 0,
+		"DB_CONN_MAX_LIFETIME and DB_CONN_MAX_IDLE_TIME can't be negative")
+	check(c.HTTPMaxHeaderBytes > 0, "HTTP_MAX_HEADER_BYTES must be positive")
+	durations := map[string]time.Duration{
+		"SUBSCRIPTION_EXPIRY_INTERVAL": c.SubscriptionExpiryInterval,
+		"WEBHOOK_POLL_INTERVAL":        c.WebhookPollInterval,
+		"SHUTDOWN_TIMEOUT":             c.ShutdownTimeout,
+		"READINESS_TIMEOUT":            c.ReadinessTimeout,
+		"DB_CONNECT_TIMEOUT":           c.DBConnectTimeout,
+		"DB_QUERY_TIMEOUT":             c.DBQueryTimeout,
+	}
+	for key, d := range durations {
+		check(d > 0, "%s must be positive", key)
+	}
+	return errors.Join(errs...)
+}
+
+// Print writes the effective settings as VAR=value lines with secrets
+// redacted.
+func (c *Config) Print(w io.Writer) {
+	v := reflect.ValueOf(c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		var value string
+		switch fv := v.Field(i).Interface().(type) {
+		case []string:
+			value = strings.Join(fv, ",")
+		default:
+			value = fmt.Sprint(fv)
+		}
+		fmt.Fprintf(w, "%s=%s\n", field.Tag.Get("env"), redact(field.Tag.Get("secret"), value))
+	}
+}
+
+func redact(mode, value string) string {
+	if mode == "" || value == "" {
+		return value
+	}
+	if mode == "password" {
+		u, err := url.Parse(value)
+		if err == nil && u.Scheme != "" {
+			return u.Redacted()
+		}
+	}
+	return "[redacted]"
+}
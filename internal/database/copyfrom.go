@@ -0,0 +1,46 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.28.0
+// source: copyfrom.go
+
+package database
+
+import (
+	"context"
+)
+
+// iteratorForCopyChirps implements pgx.CopyFromSource.
+type iteratorForCopyChirps struct {
+	rows                 []CopyChirpsParams
+	skippedFirstNextCall bool
+}
+
+func (r *iteratorForCopyChirps) Next() bool {
+	if len(r.rows) == 0 {
+		return false
+	}
+	if !r.skippedFirstNextCall {
+		r.skippedFirstNextCall = true
+		return true
+	}
+	r.rows = r.rows[1:]
+	return len(r.rows) > 0
+}
+
+func (r iteratorForCopyChirps) Values() ([]interface{}, error) {
+	return []interface{}{
+		r.rows[0].ID,
+		r.rows[0].CreatedAt,
+		r.rows[0].UpdatedAt,
+		r.rows[0].Body,
+		r.rows[0].UserID,
+	}, nil
+}
+
+func (r iteratorForCopyChirps) Err() error {
+	return nil
+}
+
+func (q *Queries) CopyChirps(ctx context.Context, arg []CopyChirpsParams) (int64, error) {
+	return q.db.CopyFrom(ctx, []string{"chirps"}, []string{"id", "created_at", "updated_at", "body", "user_id"}, &iteratorForCopyChirps{rows: arg})
+}
@@ -0,0 +1,198 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.28.0
+// source: oauth.sql
+
+package database
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/google/uuid"
+)
+
+const createAuthorizationCode = `-- name: CreateAuthorizationCode :exec
+INSERT INTO oauth_authorization_codes (code_hash, created_at, client_id, user_id, redirect_uri, scopes, code_challenge, expires_at, used_at)
+VALUES (
+    $1,
+    NOW(),
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    NOW() + INTERVAL '10 minutes',
+    NULL
+)
+`
+
+type CreateAuthorizationCodeParams struct {
+	CodeHash      string
+	ClientID      string
+	UserID        uuid.UUID
+	RedirectUri   string
+	Scopes        []string
+	CodeChallenge string
+}
+
+func (q *Queries) CreateAuthorizationCode(ctx context.Context, arg CreateAuthorizationCodeParams) error {
+	_, err := q.db.Exec(ctx, createAuthorizationCode,
+		arg.CodeHash,
+		arg.ClientID,
+		arg.UserID,
+		arg.RedirectUri,
+		arg.Scopes,
+		arg.CodeChallenge,
+	)
+	return err
+}
+
+const createOAuthClient = `-- name: CreateOAuthClient :one
+INSERT INTO oauth_clients (id, created_at, updated_at, name, secret_hash, redirect_uris, scopes, owner_id)
+VALUES (
+    $1,
+    NOW(),
+    NOW(),
+    $2,
+    $3,
+    $4,
+    $5,
+    $6
+)
+RETURNING id, created_at, updated_at, name, secret_hash, redirect_uris, scopes, owner_id
+`
+
+type CreateOAuthClientParams struct {
+	ID           string
+	Name         string
+	SecretHash   sql.NullString
+	RedirectUris []string
+	Scopes       []string
+	OwnerID      uuid.UUID
+}
+
+func (q *Queries) CreateOAuthClient(ctx context.Context, arg CreateOAuthClientParams) (OauthClient, error) {
+	row := q.db.QueryRow(ctx, createOAuthClient,
+		arg.ID,
+		arg.Name,
+		arg.SecretHash,
+		arg.RedirectUris,
+		arg.Scopes,
+		arg.OwnerID,
+	)
+	var i OauthClient
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Name,
+		&i.SecretHash,
+		&i.RedirectUris,
+		&i.Scopes,
+		&i.OwnerID,
+	)
+	return i, err
+}
+
+const getAuthorizationCodeForUpdate = `-- name: GetAuthorizationCodeForUpdate :one
+SELECT code_hash, created_at, client_id, user_id, redirect_uri, scopes, code_challenge, expires_at, used_at FROM oauth_authorization_codes
+WHERE code_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+FOR UPDATE
+`
+
+func (q *Queries) GetAuthorizationCodeForUpdate(ctx context.Context, codeHash string) (OauthAuthorizationCode, error) {
+	row := q.db.QueryRow(ctx, getAuthorizationCodeForUpdate, codeHash)
+	var i OauthAuthorizationCode
+	err := row.Scan(
+		&i.CodeHash,
+		&i.CreatedAt,
+		&i.ClientID,
+		&i.UserID,
+		&i.RedirectUri,
+		&i.Scopes,
+		&i.CodeChallenge,
+		&i.ExpiresAt,
+		&i.UsedAt,
+	)
+	return i, err
+}
+
+const getOAuthClient = `-- name: GetOAuthClient :one
+SELECT id, created_at, updated_at, name, secret_hash, redirect_uris, scopes, owner_id FROM oauth_clients
+WHERE id = $1
+`
+
+func (q *Queries) GetOAuthClient(ctx context.Context, id string) (OauthClient, error) {
+	row := q.db.QueryRow(ctx, getOAuthClient, id)
+	var i OauthClient
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Name,
+		&i.SecretHash,
+		&i.RedirectUris,
+		&i.Scopes,
+		&i.OwnerID,
+	)
+	return i, err
+}
+
+const getOAuthConsent = `-- name: GetOAuthConsent :one
+SELECT user_id, client_id, created_at, updated_at, scopes FROM oauth_consents
+WHERE user_id = $1 AND client_id = $2
+`
+
+type GetOAuthConsentParams struct {
+	UserID   uuid.UUID
+	ClientID string
+}
+
+func (q *Queries) GetOAuthConsent(ctx context.Context, arg GetOAuthConsentParams) (OauthConsent, error) {
+	row := q.db.QueryRow(ctx, getOAuthConsent, arg.UserID, arg.ClientID)
+	var i OauthConsent
+	err := row.Scan(
+		&i.UserID,
+		&i.ClientID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Scopes,
+	)
+	return i, err
+}
+
+const upsertOAuthConsent = `-- name: UpsertOAuthConsent :exec
+INSERT INTO oauth_consents (user_id, client_id, created_at, updated_at, scopes)
+VALUES (
+    $1,
+    $2,
+    NOW(),
+    NOW(),
+    $3
+)
+ON CONFLICT (user_id, client_id)
+DO UPDATE SET scopes = EXCLUDED.scopes, updated_at = NOW()
+`
+
+type UpsertOAuthConsentParams struct {
+	UserID   uuid.UUID
+	ClientID string
+	Scopes   []string
+}
+
+func (q *Queries) UpsertOAuthConsent(ctx context.Context, arg UpsertOAuthConsentParams) error {
+	_, err := q.db.Exec(ctx, upsertOAuthConsent, arg.UserID, arg.ClientID, arg.Scopes)
+	return err
+}
+
+const useAuthorizationCode = `-- name: UseAuthorizationCode :exec
+UPDATE oauth_authorization_codes
+SET used_at = NOW()
+WHERE code_hash = $1
+`
+
+func (q *Queries) UseAuthorizationCode(ctx context.Context, codeHash string) error {
+	_, err := q.db.Exec(ctx, useAuthorizationCode, codeHash)
+	return err
+}
@@ -0,0 +1,63 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.28.0
+// source: oidc_identities.sql
+
+package database
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+const createOIDCIdentity = `-- name: CreateOIDCIdentity :exec
+INSERT INTO oidc_identities (issuer, subject, created_at, user_id, email)
+VALUES (
+    $1,
+    $2,
+    NOW(),
+    $3,
+    $4
+)
+`
+
+type CreateOIDCIdentityParams struct {
+	Issuer  string
+	Subject string
+	UserID  uuid.UUID
+	Email   string
+}
+
+func (q *Queries) CreateOIDCIdentity(ctx context.Context, arg CreateOIDCIdentityParams) error {
+	_, err := q.db.Exec(ctx, createOIDCIdentity,
+		arg.Issuer,
+		arg.Subject,
+		arg.UserID,
+		arg.Email,
+	)
+	return err
+}
+
+const getOIDCIdentity = `-- name: GetOIDCIdentity :one
+SELECT issuer, subject, created_at, user_id, email FROM oidc_identities
+WHERE issuer = $1 AND subject = $2
+`
+
+type GetOIDCIdentityParams struct {
+	Issuer  string
+	Subject string
+}
+
+func (q *Queries) GetOIDCIdentity(ctx context.Context, arg GetOIDCIdentityParams) (OidcIdentity, error) {
+	row := q.db.QueryRow(ctx, getOIDCIdentity, arg.Issuer, arg.Subject)
+	var i OidcIdentity
+	err := row.Scan(
+		&i.Issuer,
+		&i.Subject,
+		&i.CreatedAt,
+		&i.UserID,
+		&i.Email,
+	)
+	return i, err
+}
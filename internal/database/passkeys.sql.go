@@ -0,0 +1,218 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.28.0
+// source: passkeys.sql
+
+package database
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/google/uuid"
+)
+
+const createPasskey = `-- name: CreatePasskey :one
+INSERT INTO passkeys (id, created_at, updated_at, user_id, name, credential_id, public_key, attestation_type, aaguid, sign_count, transports, backup_eligible, backup_state)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    $7,
+    $8,
+    $9,
+    $10
+)
+RETURNING id, created_at, updated_at, user_id, name, credential_id, public_key, attestation_type, aaguid, sign_count, transports, backup_eligible, backup_state, last_used_at
+`
+
+type CreatePasskeyParams struct {
+	UserID          uuid.UUID
+	Name            string
+	CredentialID    []byte
+	PublicKey       []byte
+	AttestationType string
+	Aaguid          []byte
+	SignCount       int64
+	Transports      []string
+	BackupEligible  bool
+	BackupState     bool
+}
+
+func (q *Queries) CreatePasskey(ctx context.Context, arg CreatePasskeyParams) (Passkey, error) {
+	row := q.db.QueryRow(ctx, createPasskey,
+		arg.UserID,
+		arg.Name,
+		arg.CredentialID,
+		arg.PublicKey,
+		arg.AttestationType,
+		arg.Aaguid,
+		arg.SignCount,
+		arg.Transports,
+		arg.BackupEligible,
+		arg.BackupState,
+	)
+	var i Passkey
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.UserID,
+		&i.Name,
+		&i.CredentialID,
+		&i.PublicKey,
+		&i.AttestationType,
+		&i.Aaguid,
+		&i.SignCount,
+		&i.Transports,
+		&i.BackupEligible,
+		&i.BackupState,
+		&i.LastUsedAt,
+	)
+	return i, err
+}
+
+const createWebAuthnSession = `-- name: CreateWebAuthnSession :one
+INSERT INTO webauthn_sessions (id, created_at, user_id, email, data, expires_at)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    NOW() + INTERVAL '5 minutes'
+)
+RETURNING id, created_at, user_id, data, expires_at, email
+`
+
+type CreateWebAuthnSessionParams struct {
+	UserID uuid.NullUUID
+	Email  sql.NullString
+	Data   []byte
+}
+
+func (q *Queries) CreateWebAuthnSession(ctx context.Context, arg CreateWebAuthnSessionParams) (WebauthnSession, error) {
+	row := q.db.QueryRow(ctx, createWebAuthnSession, arg.UserID, arg.Email, arg.Data)
+	var i WebauthnSession
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UserID,
+		&i.Data,
+		&i.ExpiresAt,
+		&i.Email,
+	)
+	return i, err
+}
+
+const deleteExpiredWebAuthnSessions = `-- name: DeleteExpiredWebAuthnSessions :exec
+DELETE FROM webauthn_sessions
+WHERE expires_at <= NOW()
+`
+
+func (q *Queries) DeleteExpiredWebAuthnSessions(ctx context.Context) error {
+	_, err := q.db.Exec(ctx, deleteExpiredWebAuthnSessions)
+	return err
+}
+
+const deletePasskey = `-- name: DeletePasskey :execrows
+DELETE FROM passkeys
+WHERE id = $1 AND user_id = $2
+`
+
+type DeletePasskeyParams struct {
+	ID     uuid.UUID
+	UserID uuid.UUID
+}
+
+func (q *Queries) DeletePasskey(ctx context.Context, arg DeletePasskeyParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deletePasskey, arg.ID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const getPasskeysByUserID = `-- name: GetPasskeysByUserID :many
+SELECT id, created_at, updated_at, user_id, name, credential_id, public_key, attestation_type, aaguid, sign_count, transports, backup_eligible, backup_state, last_used_at FROM passkeys
+WHERE user_id = $1
+ORDER BY created_at
+`
+
+func (q *Queries) GetPasskeysByUserID(ctx context.Context, userID uuid.UUID) ([]Passkey, error) {
+	rows, err := q.db.Query(ctx, getPasskeysByUserID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Passkey
+	for rows.Next() {
+		var i Passkey
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.UserID,
+			&i.Name,
+			&i.CredentialID,
+			&i.PublicKey,
+			&i.AttestationType,
+			&i.Aaguid,
+			&i.SignCount,
+			&i.Transports,
+			&i.BackupEligible,
+			&i.BackupState,
+			&i.LastUsedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const takeWebAuthnSession = `-- name: TakeWebAuthnSession :one
+DELETE FROM webauthn_sessions
+WHERE id = $1 AND expires_at > NOW()
+RETURNING id, created_at, user_id, data, expires_at, email
+`
+
+func (q *Queries) TakeWebAuthnSession(ctx context.Context, id uuid.UUID) (WebauthnSession, error) {
+	row := q.db.QueryRow(ctx, takeWebAuthnSession, id)
+	var i WebauthnSession
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UserID,
+		&i.Data,
+		&i.ExpiresAt,
+		&i.Email,
+	)
+	return i, err
+}
+
+const updatePasskeyAfterLogin = `-- name: UpdatePasskeyAfterLogin :exec
+UPDATE passkeys
+SET sign_count = $1, backup_state = $2, last_used_at = NOW(), updated_at = NOW()
+WHERE credential_id = $3
+`
+
+type UpdatePasskeyAfterLoginParams struct {
+	SignCount    int64
+	BackupState  bool
+	CredentialID []byte
+}
+
+func (q *Queries) UpdatePasskeyAfterLogin(ctx context.Context, arg UpdatePasskeyAfterLoginParams) error {
+	_, err := q.db.Exec(ctx, updatePasskeyAfterLogin, arg.SignCount, arg.BackupState, arg.CredentialID)
+	return err
+}
@@ -0,0 +1,29 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.28.0
+// source: polka_events.sql
+
+package database
+
+import (
+	"context"
+)
+
+const recordPolkaEvent = `-- name: RecordPolkaEvent :execrows
+INSERT INTO polka_events (id, event, received_at)
+VALUES ($1, $2, NOW())
+ON CONFLICT (id) DO NOTHING
+`
+
+type RecordPolkaEventParams struct {
+	ID    string
+	Event string
+}
+
+func (q *Queries) RecordPolkaEvent(ctx context.Context, arg RecordPolkaEventParams) (int64, error) {
+	result, err := q.db.Exec(ctx, recordPolkaEvent, arg.ID, arg.Event)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
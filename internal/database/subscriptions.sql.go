@@ -0,0 +1,135 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.28.0
+// source: subscriptions.sql
+
+package database
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/google/uuid"
+)
+
+const createSubscriptionEvent = `-- name: CreateSubscriptionEvent :exec
+INSERT INTO subscription_events (id, subscription_id, polka_event_id, event, status, current_period_end, created_at)
+VALUES (
+    gen_random_uuid(),
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    NOW()
+)
+`
+
+type CreateSubscriptionEventParams struct {
+	SubscriptionID   uuid.UUID
+	PolkaEventID     string
+	Event            string
+	Status           string
+	CurrentPeriodEnd sql.NullTime
+}
+
+func (q *Queries) CreateSubscriptionEvent(ctx context.Context, arg CreateSubscriptionEventParams) error {
+	_, err := q.db.Exec(ctx, createSubscriptionEvent,
+		arg.SubscriptionID,
+		arg.PolkaEventID,
+		arg.Event,
+		arg.Status,
+		arg.CurrentPeriodEnd,
+	)
+	return err
+}
+
+const expireSubscriptions = `-- name: ExpireSubscriptions :many
+WITH expired AS (
+    UPDATE subscriptions
+    SET status = 'expired', updated_at = NOW()
+    WHERE current_period_end < NOW()
+    AND status IN ('active', 'past_due', 'canceled')
+    RETURNING user_id
+)
+UPDATE users
+SET is_chirpy_red = false, updated_at = NOW()
+WHERE id IN (SELECT user_id FROM expired)
+RETURNING id
+`
+
+func (q *Queries) ExpireSubscriptions(ctx context.Context) ([]uuid.UUID, error) {
+	rows, err := q.db.Query(ctx, expireSubscriptions)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getSubscriptionByUserID = `-- name: GetSubscriptionByUserID :one
+SELECT id, created_at, updated_at, user_id, status, current_period_end FROM subscriptions
+WHERE user_id = $1
+`
+
+func (q *Queries) GetSubscriptionByUserID(ctx context.Context, userID uuid.UUID) (Subscription, error) {
+	row := q.db.QueryRow(ctx, getSubscriptionByUserID, userID)
+	var i Subscription
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.UserID,
+		&i.Status,
+		&i.CurrentPeriodEnd,
+	)
+	return i, err
+}
+
+const upsertSubscription = `-- name: UpsertSubscription :one
+INSERT INTO subscriptions (id, created_at, updated_at, user_id, status, current_period_end)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    $2,
+    $3
+)
+ON CONFLICT (user_id) DO UPDATE
+SET status = EXCLUDED.status,
+    current_period_end = EXCLUDED.current_period_end,
+    updated_at = NOW()
+RETURNING id, created_at, updated_at, user_id, status, current_period_end
+`
+
+type UpsertSubscriptionParams struct {
+	UserID           uuid.UUID
+	Status           string
+	CurrentPeriodEnd sql.NullTime
+}
+
+func (q *Queries) UpsertSubscription(ctx context.Context, arg UpsertSubscriptionParams) (Subscription, error) {
+	row := q.db.QueryRow(ctx, upsertSubscription, arg.UserID, arg.Status, arg.CurrentPeriodEnd)
+	var i Subscription
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.UserID,
+		&i.Status,
+		&i.CurrentPeriodEnd,
+	)
+	return i, err
+}
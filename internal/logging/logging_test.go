@@ -0,0 +1,87 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	if _, err := New(&bytes.Buffer{}, "json", "debug"); err != nil {
+		t.Fatalf("json logger rejected: %v", err)
+	}
+	if _, err := New(&bytes.Buffer{}, "xml", "info"); err == nil {
+		t.Fatalf("unknown format accepted")
+	}
+	if _, err := New(&bytes.Buffer{}, "text", "loud"); err == nil {
+		t.Fatalf("unknown level accepted")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger, _ := New(buf, "json", "info")
+	var seenID string
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/chirps/{chirpID}", func(w http.ResponseWriter, r *http.Request) {
+		seenID = RequestID(r.Context())
+		RecordUser(w, "user-1")
+		RecordError(w, "unable to find chirp", errors.New("no rows"))
+		w.WriteHeader(http.StatusNotFound)
+	})
+	handler := Middleware(logger, mux)
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/api/chirps/123", nil))
+	generated := recorder.Header().Get(RequestIDHeader)
+	if generated == "" || generated != seenID {
+		t.Fatalf("request id not generated and placed in context")
+	}
+	entry := map[string]interface{}{}
+	err := json.Unmarshal(buf.Bytes(), &entry)
+	if err != nil {
+		t.Fatalf("access log is not json: %s", buf.String())
+	}
+	expected := map[string]interface{}{
+		"level":      "WARN",
+		"request_id": generated,
+		"route":      "GET /api/chirps/{chirpID}",
+		"user_id":    "user-1",
+		"error":      "no rows",
+		"status":     float64(404),
+	}
+	for key, value := range expected {
+		if entry[key] != value {
+			t.Errorf("log field %s: got %v, want %v", key, entry[key], value)
+		}
+	}
+
+	request := httptest.NewRequest("GET", "/api/chirps/123", nil)
+	request.Header.Set(RequestIDHeader, "from-proxy")
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	if recorder.Header().Get(RequestIDHeader) != "from-proxy" || seenID != "from-proxy" {
+		t.Fatalf("upstream request id not propagated")
+	}
+	request.Header.Set(RequestIDHeader, "bad id\nwith newline")
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	if recorder.Header().Get(RequestIDHeader) == "bad id\nwith newline" {
+		t.Fatalf("unsafe request id echoed")
+	}
+}
+
+func TestContextHandlerAddsRequestID(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger, _ := New(buf, "json", "info")
+	logger.ErrorContext(WithRequestID(context.Background(), "abc"), "error sending email")
+	entry := map[string]interface{}{}
+	json.Unmarshal(buf.Bytes(), &entry)
+	if entry["request_id"] != "abc" {
+		t.Fatalf("request id missing from handler log: %s", buf.String())
+	}
+}
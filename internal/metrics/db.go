@@ -0,0 +1,162 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DBTX matches the interface sqlc generates in internal/database.
+type DBTX interface {
+	Exec(context.Context, string, ...interface{}) (pgconn.CommandTag, error)
+	Query(context.Context, string, ...interface{}) (pgx.Rows, error)
+	QueryRow(context.Context, string, ...interface{}) pgx.Row
+	CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error)
+}
+
+type instrumentedDB struct {
+	db      DBTX
+	metrics *Metrics
+}
+
+// InstrumentDB times every query run through db. Queries made on a
+// transaction from Queries.WithTx bypass the wrapper.
+func (m *Metrics) InstrumentDB(db DBTX) DBTX {
+	return &instrumentedDB{db: db, metrics: m}
+}
+
+func (i *instrumentedDB) observe(name string, start time.Time, err error) {
+	i.metrics.queryDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		i.metrics.queryErrors.WithLabelValues(name).Inc()
+	}
+}
+
+func (i *instrumentedDB) Exec(ctx context.Context, query string, args ...interface{}) (pgconn.CommandTag, error) {
+	start := time.Now()
+	tag, err := i.db.Exec(ctx, query, args...)
+	i.observe(QueryName(query), start, err)
+	return tag, err
+}
+
+func (i *instrumentedDB) Query(ctx context.Context, query string, args ...interface{}) (pgx.Rows, error) {
+	start := time.Now()
+	rows, err := i.db.Query(ctx, query, args...)
+	if err != nil {
+		i.observe(QueryName(query), start, err)
+		return nil, err
+	}
+	return &observedRows{Rows: rows, db: i, name: QueryName(query), start: start}, nil
+}
+
+func (i *instrumentedDB) QueryRow(ctx context.Context, query string, args ...interface{}) pgx.Row {
+	start := time.Now()
+	row := i.db.QueryRow(ctx, query, args...)
+	return &observedRow{row: row, db: i, name: QueryName(query), start: start}
+}
+
+func (i *instrumentedDB) CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error) {
+	start := time.Now()
+	n, err := i.db.CopyFrom(ctx, tableName, columnNames, rowSrc)
+	i.observe(CopyName(tableName), start, err)
+	return n, err
+}
+
+// observedRow reports when Scan returns: pgx reads a QueryRow result
+// lazily, so that is when the query has finished and its error is known.
+type observedRow struct {
+	row   pgx.Row
+	db    *instrumentedDB
+	name  string
+	start time.Time
+}
+
+func (r *observedRow) Scan(dest ...any) error {
+	err := r.row.Scan(dest...)
+	r.db.observe(r.name, r.start, err)
+	return err
+}
+
+// observedRows reports on Close: Query returns before the rows are read, so
+// that is when the query has finished and its error is known.
+type observedRows struct {
+	pgx.Rows
+	db       *instrumentedDB
+	name     string
+	start    time.Time
+	observed bool
+}
+
+func (r *observedRows) Close() {
+	r.Rows.Close()
+	if !r.observed {
+		r.observed = true
+		r.db.observe(r.name, r.start, r.Rows.Err())
+	}
+}
+
+// QueryName extracts the name from the "-- name: GetUser :one" comment sqlc
+// puts at the top of each query, so labels stay low-cardinality.
+func QueryName(query string) string {
+	rest, ok := strings.CutPrefix(query, "-- name: ")
+	if !ok {
+		return "unknown"
+	}
+	name, _, _ := strings.Cut(rest, " ")
+	return name
+}
+
+// CopyName names a COPY into tableName, which has no query text to take a
+// name from.
+func CopyName(tableName pgx.Identifier) string {
+	return "copy_" + strings.Join(tableName, ".")
+}
+
+var (
+	poolMaxConns = prometheus.NewDesc("db_pool_max_conns",
+		"Maximum size of the connection pool.", nil, nil)
+	poolTotalConns = prometheus.NewDesc("db_pool_total_conns",
+		"Connections currently open, including ones being established.", nil, nil)
+	poolAcquiredConns = prometheus.NewDesc("db_pool_acquired_conns",
+		"Connections currently in use.", nil, nil)
+	poolIdleConns = prometheus.NewDesc("db_pool_idle_conns",
+		"Connections currently idle.", nil, nil)
+	poolAcquires = prometheus.NewDesc("db_pool_acquires_total",
+		"Connections acquired from the pool.", nil, nil)
+	poolEmptyAcquires = prometheus.NewDesc("db_pool_empty_acquires_total",
+		"Acquires that had to wait for a connection because none was idle.", nil, nil)
+	poolAcquireWait = prometheus.NewDesc("db_pool_acquire_wait_seconds_total",
+		"Time spent waiting for a connection.", nil, nil)
+)
+
+// poolCollector reads the pool's stats on each scrape.
+type poolCollector struct {
+	pool *pgxpool.Pool
+}
+
+func (c poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(c, ch)
+}
+
+func (c poolCollector) Collect(ch chan<- prometheus.Metric) {
+	stat := c.pool.Stat()
+	ch <- prometheus.MustNewConstMetric(poolMaxConns, prometheus.GaugeValue, float64(stat.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(poolTotalConns, prometheus.GaugeValue, float64(stat.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(poolAcquiredConns, prometheus.GaugeValue, float64(stat.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(poolIdleConns, prometheus.GaugeValue, float64(stat.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(poolAcquires, prometheus.CounterValue, float64(stat.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolEmptyAcquires, prometheus.CounterValue, float64(stat.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolAcquireWait, prometheus.CounterValue, stat.AcquireDuration().Seconds())
+}
+
+// InstrumentPool exports the connection pool stats (open, in use and idle
+// connections, waits for a free one) on each scrape.
+func (m *Metrics) InstrumentPool(pool *pgxpool.Pool) {
+	m.Registry.MustRegister(poolCollector{pool: pool})
+}
@@ -0,0 +1,116 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func TestQueryName(t *testing.T) {
+	cases := map[string]string{
+		"-- name: GetUser :one\nSELECT * FROM users":    "GetUser",
+		"-- name: DeleteUsers :exec\nDELETE FROM users": "DeleteUsers",
+		"SELECT 1": "unknown",
+	}
+	for query, expected := range cases {
+		if got := QueryName(query); got != expected {
+			t.Errorf("query %q: got %s, want %s", query, got, expected)
+		}
+	}
+}
+
+func TestMiddlewareLabelsByPattern(t *testing.T) {
+	m := New()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/chirps/{chirpID}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	handler := m.Middleware(mux)
+	for _, path := range []string{"/api/chirps/1", "/api/chirps/2", "/nowhere"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	recorder := httptest.NewRecorder()
+	m.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(recorder.Body)
+	exposition := string(body)
+	expected := []string{
+		`http_requests_total{code="404",method="GET",route="GET /api/chirps/{chirpID}"} 2`,
+		`http_requests_total{code="404",method="GET",route="unmatched"} 1`,
+		`http_requests_in_flight 0`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(exposition, line) {
+			t.Errorf("metrics missing %s", line)
+		}
+	}
+}
+
+func TestInstrumentPool(t *testing.T) {
+	// the pool connects lazily, so no database is needed
+	pool, err := pgxpool.New(context.Background(), "postgres://localhost/chirpy?pool_max_conns=7")
+	if err != nil {
+		t.Fatalf("creating pool: %v", err)
+	}
+	defer pool.Close()
+	m := New()
+	m.InstrumentPool(pool)
+
+	recorder := httptest.NewRecorder()
+	m.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(recorder.Body.String(), "db_pool_max_conns 7") {
+		t.Errorf("pool stats missing from metrics")
+	}
+}
+
+type rowsDB struct {
+	DBTX
+	rows pgx.Rows
+}
+
+func (db rowsDB) Query(context.Context, string, ...interface{}) (pgx.Rows, error) {
+	return db.rows, nil
+}
+
+// failingRows has no rows and fails as they are read, like a connection lost
+// mid-result.
+type failingRows struct {
+	pgx.Rows
+}
+
+func (*failingRows) Next() bool { return false }
+func (*failingRows) Close()     {}
+func (*failingRows) Err() error { return errors.New("connection reset") }
+
+func TestQueryObservedOnClose(t *testing.T) {
+	m := New()
+	db := m.InstrumentDB(rowsDB{rows: &failingRows{}})
+	rows, err := db.Query(context.Background(), "-- name: GetChirps :many\nSELECT * FROM chirps")
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	for rows.Next() {
+	}
+	rows.Close()
+	rows.Close()
+
+	recorder := httptest.NewRecorder()
+	m.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	exposition := recorder.Body.String()
+	expected := []string{
+		`db_query_errors_total{query="GetChirps"} 1`,
+		`db_query_duration_seconds_count{query="GetChirps"} 1`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(exposition, line) {
+			t.Errorf("metrics missing %s", line)
+		}
+	}
+}
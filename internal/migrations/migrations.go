@@ -0,0 +1,123 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"strconv"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/pressly/goose/v3"
+	"github.com/pressly/goose/v3/lock"
+	"github.com/raffkelly/chirpy/sql/schema"
+)
+
+// New returns a goose provider for the migrations embedded from sql/schema.
+// Migrations run while holding a Postgres advisory lock, so replicas
+// migrating on boot at the same time take turns instead of racing. Goose
+// needs database/sql, so it borrows connections from pool through pgx's
+// stdlib adapter.
+func New(pool *pgxpool.Pool) (*goose.Provider, error) {
+	locker, err := lock.NewPostgresSessionLocker()
+	if err != nil {
+		return nil, err
+	}
+	db := stdlib.OpenDBFromPool(pool)
+	return goose.NewProvider(goose.DialectPostgres, db, schema.FS, goose.WithSessionLocker(locker))
+}
+
+// Latest is the newest embedded migration, the schema version this binary
+// was built for.
+func Latest() int64 {
+	files, _ := fs.Glob(schema.FS, "*.sql")
+	var latest int64
+	for _, file := range files {
+		version, err := goose.NumericComponent(file)
+		if err == nil && version > latest {
+			latest = version
+		}
+	}
+	return latest
+}
+
+// CheckVersion fails if the database was migrated by a newer release than
+// this binary: its queries may not match the schema any more, and rolling
+// back is a job for the newer binary that knows the down migrations.
+func CheckVersion(ctx context.Context, provider *goose.Provider) (int64, error) {
+	current, err := provider.GetDBVersion(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("reading schema version: %w", err)
+	}
+	if latest := Latest(); current > latest {
+		return current, fmt.Errorf("database schema is at version %d but this binary only knows up to %d; deploy a newer release", current, latest)
+	}
+	return current, nil
+}
+
+// Run implements "chirpy migrate up|down|status|to VERSION", writing what
+// it did to w.
+func Run(ctx context.Context, provider *goose.Provider, args []string, w io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: chirpy migrate up|down|status|to VERSION")
+	}
+	var results []*goose.MigrationResult
+	var err error
+	switch command := args[0]; {
+	case command == "up" && len(args) == 1:
+		results, err = provider.Up(ctx)
+	case command == "down" && len(args) == 1:
+		var result *goose.MigrationResult
+		result, err = provider.Down(ctx)
+		if result != nil {
+			results = append(results, result)
+		}
+	case command == "to" && len(args) == 2:
+		results, err = migrateTo(ctx, provider, args[1])
+	case command == "status" && len(args) == 1:
+		return printStatus(ctx, provider, w)
+	default:
+		return fmt.Errorf("usage: chirpy migrate up|down|status|to VERSION")
+	}
+	for _, result := range results {
+		fmt.Fprintln(w, result)
+	}
+	if err != nil {
+		return err
+	}
+	if len(results) == 0 {
+		fmt.Fprintln(w, "no migrations to run")
+	}
+	return nil
+}
+
+func migrateTo(ctx context.Context, provider *goose.Provider, arg string) ([]*goose.MigrationResult, error) {
+	target, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || target < 0 {
+		return nil, fmt.Errorf("invalid version %q", arg)
+	}
+	current, err := provider.GetDBVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if target < current {
+		return provider.DownTo(ctx, target)
+	}
+	return provider.UpTo(ctx, target)
+}
+
+func printStatus(ctx context.Context, provider *goose.Provider, w io.Writer) error {
+	statuses, err := provider.Status(ctx)
+	if err != nil {
+		return err
+	}
+	for _, status := range statuses {
+		applied := "pending"
+		if status.State == goose.StateApplied {
+			applied = "applied " + status.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(w, "%-40s %s\n", status.Source.Path, applied)
+	}
+	return nil
+}
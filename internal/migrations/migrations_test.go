@@ -0,0 +1,22 @@
+package migrations
+
+import (
+	"io/fs"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/raffkelly/chirpy/sql/schema"
+)
+
+func TestLatestMatchesEmbeddedFiles(t *testing.T) {
+	files, err := fs.Glob(schema.FS, "*.sql")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no migrations embedded")
+	}
+	newest, _, _ := strings.Cut(files[len(files)-1], "_")
+	version, _ := strconv.ParseInt(newest, 10, 64)
+	if got := Latest(); got != version {
+		t.Fatalf("got latest %d, want %d", got, version)
+	}
+}
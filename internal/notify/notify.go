@@ -0,0 +1,106 @@
+package notify
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Listener dispatches Postgres notifications, sent with NOTIFY or the
+// Notify query, to handlers registered by other subsystems. It keeps one
+// connection out of the pool for as long as it runs.
+//
+// Postgres doesn't queue notifications for a connection that is gone, so
+// some are lost while the listener reconnects. Treat them as a hint to act
+// sooner and keep polling for anything missed.
+type Listener struct {
+	pool     *pgxpool.Pool
+	handlers map[string][]func(payload string)
+}
+
+const (
+	minBackoff = 500 * time.Millisecond
+	maxBackoff = 10 * time.Second
+)
+
+func NewListener(pool *pgxpool.Pool) *Listener {
+	return &Listener{pool: pool, handlers: make(map[string][]func(string))}
+}
+
+// Handle calls fn with the payload of every notification on channel. All
+// handlers must be registered before Run. They run on the listener's
+// goroutine, so they must not block.
+func (l *Listener) Handle(channel string, fn func(payload string)) {
+	l.handlers[channel] = append(l.handlers[channel], fn)
+}
+
+// Wake returns a channel that receives when a notification arrives on
+// channel. Notifications that arrive before the receiver gets to it are
+// coalesced into one wake-up, which suits a worker that then handles
+// everything pending.
+func (l *Listener) Wake(channel string) <-chan struct{} {
+	wake := make(chan struct{}, 1)
+	l.Handle(channel, func(string) {
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	})
+	return wake
+}
+
+// Run listens until ctx is done, reconnecting with backoff when the
+// connection is lost.
+func (l *Listener) Run(ctx context.Context) {
+	if len(l.handlers) == 0 {
+		return
+	}
+	backoff := minBackoff
+	for {
+		listening, err := l.listen(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if listening {
+			backoff = minBackoff
+		}
+		slog.Warn("notification listener disconnected, retrying", "retry_in", backoff, "error", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// listen subscribes to every handled channel on a fresh connection and
+// dispatches notifications until it fails. It reports whether it got as
+// far as listening.
+func (l *Listener) listen(ctx context.Context) (bool, error) {
+	pooled, err := l.pool.Acquire(ctx)
+	if err != nil {
+		return false, err
+	}
+	// a subscribed connection must not go back to the pool
+	conn := pooled.Hijack()
+	defer conn.Close(context.Background())
+	for channel := range l.handlers {
+		_, err = conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize())
+		if err != nil {
+			return false, err
+		}
+	}
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return true, err
+		}
+		for _, handle := range l.handlers[notification.Channel] {
+			handle(notification.Payload)
+		}
+	}
+}
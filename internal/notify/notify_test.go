@@ -0,0 +1,52 @@
+package notify
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func TestWakeCoalesces(t *testing.T) {
+	l := NewListener(nil)
+	wake := l.Wake("events")
+	for _, handle := range l.handlers["events"] {
+		handle("1")
+		handle("2")
+	}
+	select {
+	case <-wake:
+	default:
+		t.Fatalf("no wake-up after a notification")
+	}
+	select {
+	case <-wake:
+		t.Fatalf("got a second wake-up for one burst")
+	default:
+	}
+}
+
+func TestRunStopsWhenUnreachable(t *testing.T) {
+	// nothing listens on port 1
+	pool, err := pgxpool.New(context.Background(), "postgres://chirpy@127.0.0.1:1/chirpy?sslmode=disable")
+	if err != nil {
+		t.Fatalf("creating pool: %v", err)
+	}
+	defer pool.Close()
+	l := NewListener(pool)
+	l.Wake("events")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		l.Run(ctx)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatalf("Run kept retrying after ctx was done")
+	}
+}
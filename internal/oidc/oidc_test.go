@@ -0,0 +1,147 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// stubIdP is a minimal OpenID provider: discovery, a key set and a token
+// endpoint that returns whatever ID token the test configures.
+type stubIdP struct {
+	server  *httptest.Server
+	key     *rsa.PrivateKey
+	idToken string
+}
+
+func newStubIdP(t *testing.T) *stubIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("error generating rsa key")
+	}
+	idp := &stubIdP{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.server.URL,
+			"authorization_endpoint": idp.server.URL + "/authorize",
+			"token_endpoint":         idp.server.URL + "/token",
+			"jwks_uri":               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		clientID, clientSecret, ok := r.BasicAuth()
+		if !ok || clientID != "chirpy" || clientSecret != "secret" || r.PostFormValue("code") != "good-code" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": idp.idToken})
+	})
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+func (idp *stubIdP) sign(t *testing.T, claims Claims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "test"
+	signed, err := token.SignedString(idp.key)
+	if err != nil {
+		t.Fatalf("error signing id token")
+	}
+	return signed
+}
+
+func (idp *stubIdP) claims(nonce string) Claims {
+	return Claims{
+		Email:         "lane@example.com",
+		EmailVerified: true,
+		Nonce:         nonce,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "user-123",
+			Issuer:    idp.server.URL,
+			Audience:  jwt.ClaimStrings{"chirpy"},
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+	}
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	idp := newStubIdP(t)
+	provider := NewProvider(idp.server.URL, "chirpy", "secret", "http://localhost:8080/api/oidc/callback")
+	authURL, err := provider.AuthCodeURL(context.Background(), "state", "nonce", "challenge")
+	if err != nil {
+		t.Fatalf("error building auth url: %v", err)
+	}
+	u, _ := url.Parse(authURL)
+	if !strings.HasSuffix(u.Path, "/authorize") || u.Query().Get("nonce") != "nonce" || u.Query().Get("code_challenge_method") != "S256" {
+		t.Fatalf("unexpected auth url %s", authURL)
+	}
+}
+
+func TestExchange(t *testing.T) {
+	idp := newStubIdP(t)
+	provider := NewProvider(idp.server.URL, "chirpy", "secret", "http://localhost:8080/api/oidc/callback")
+	idp.idToken = idp.sign(t, idp.claims("nonce"))
+	claims, err := provider.Exchange(context.Background(), "good-code", "verifier", "nonce")
+	if err != nil {
+		t.Fatalf("error exchanging code: %v", err)
+	}
+	if claims.Subject != "user-123" || claims.Email != "lane@example.com" || !claims.EmailVerified {
+		t.Fatalf("wrong claims from id token")
+	}
+	if _, err := provider.Exchange(context.Background(), "bad-code", "verifier", "nonce"); err == nil {
+		t.Fatalf("bad code accepted")
+	}
+}
+
+func TestVerifyIDTokenRejects(t *testing.T) {
+	idp := newStubIdP(t)
+	provider := NewProvider(idp.server.URL, "chirpy", "secret", "http://localhost:8080/api/oidc/callback")
+
+	wrongAudience := idp.claims("nonce")
+	wrongAudience.Audience = jwt.ClaimStrings{"someone-else"}
+	expired := idp.claims("nonce")
+	expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+	cases := map[string]string{
+		"nonce mismatch": idp.sign(t, idp.claims("other")),
+		"wrong audience": idp.sign(t, wrongAudience),
+		"expired":        idp.sign(t, expired),
+	}
+	for name, token := range cases {
+		if _, err := provider.VerifyIDToken(context.Background(), token, "nonce"); err == nil {
+			t.Errorf("%s: id token accepted", name)
+		}
+	}
+
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	forged := jwt.NewWithClaims(jwt.SigningMethodRS256, idp.claims("nonce"))
+	forged.Header["kid"] = "test"
+	forgedToken, _ := forged.SignedString(otherKey)
+	if _, err := provider.VerifyIDToken(context.Background(), forgedToken, "nonce"); err == nil {
+		t.Errorf("id token signed by unknown key accepted")
+	}
+}
@@ -0,0 +1,96 @@
+package throttle
+
+import (
+	"sync"
+	"time"
+)
+
+// Policy describes how quickly repeated failures lock a key out.
+type Policy struct {
+	// failures allowed before any delay applies
+	FreeAttempts int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	// failures are forgotten once a key has been quiet this long
+	Window time.Duration
+}
+
+// Delay returns the lockout that follows the given number of consecutive
+// failures, doubling for every failure past the free allowance.
+func (p Policy) Delay(failures int) time.Duration {
+	if failures < p.FreeAttempts {
+		return 0
+	}
+	delay := p.BaseDelay
+	for i := p.FreeAttempts; i < failures; i++ {
+		delay *= 2
+		if delay >= p.MaxDelay {
+			return p.MaxDelay
+		}
+	}
+	return min(delay, p.MaxDelay)
+}
+
+type entry struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// Tracker counts failures per key (an email, an IP address) in memory.
+type Tracker struct {
+	policy  Policy
+	mu      sync.Mutex
+	entries map[string]*entry
+}
+
+func NewTracker(policy Policy) *Tracker {
+	return &Tracker{
+		policy:  policy,
+		entries: make(map[string]*entry),
+	}
+}
+
+// Check returns how long key remains locked out, or zero if it may proceed.
+func (t *Tracker) Check(key string, now time.Time) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e, ok := t.entries[key]
+	if !ok || !now.Before(e.lockedUntil) {
+		return 0
+	}
+	return e.lockedUntil.Sub(now)
+}
+
+// Fail records a failure for key and returns the resulting lockout.
+func (t *Tracker) Fail(key string, now time.Time) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.prune(now)
+	e, ok := t.entries[key]
+	if !ok {
+		e = &entry{}
+		t.entries[key] = e
+	}
+	e.failures++
+	e.lastFailure = now
+	delay := t.policy.Delay(e.failures)
+	e.lockedUntil = now.Add(delay)
+	return delay
+}
+
+func (t *Tracker) Reset(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.entries, key)
+}
+
+// prune drops idle entries so the map cannot grow without bound. Caller
+// holds t.mu.
+func (t *Tracker) prune(now time.Time) {
+	for key, e := range t.entries {
+		if now.Sub(e.lastFailure) > t.policy.Window && !now.Before(e.lockedUntil) {
+			delete(t.entries, key)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package throttle
+
+import (
+	"testing"
+	"time"
+)
+
+var testPolicy = Policy{
+	FreeAttempts: 3,
+	BaseDelay:    time.Second,
+	MaxDelay:     time.Minute,
+	Window:       time.Hour,
+}
+
+func TestPolicyDelay(t *testing.T) {
+	cases := map[int]time.Duration{
+		0:  0,
+		2:  0,
+		3:  time.Second,
+		4:  2 * time.Second,
+		6:  8 * time.Second,
+		20: time.Minute,
+	}
+	for failures, expected := range cases {
+		if got := testPolicy.Delay(failures); got != expected {
+			t.Errorf("after %d failures expected %s but got %s", failures, expected, got)
+		}
+	}
+}
+
+func TestTrackerLockout(t *testing.T) {
+	tracker := NewTracker(testPolicy)
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		if tracker.Check("lane@example.com", now) != 0 {
+			t.Fatalf("locked out before free attempts were used")
+		}
+		tracker.Fail("lane@example.com", now)
+	}
+	if tracker.Check("lane@example.com", now) != time.Second {
+		t.Fatalf("expected lockout after free attempts")
+	}
+	if tracker.Check("other@example.com", now) != 0 {
+		t.Fatalf("lockout leaked to another key")
+	}
+	if tracker.Check("lane@example.com", now.Add(time.Second)) != 0 {
+		t.Fatalf("lockout did not expire")
+	}
+	tracker.Reset("lane@example.com")
+	if tracker.Fail("lane@example.com", now) != 0 {
+		t.Fatalf("reset did not clear failures")
+	}
+}
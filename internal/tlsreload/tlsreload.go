@@ -0,0 +1,90 @@
+package tlsreload
+
+import (
+	"crypto/tls"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Reloader serves a certificate from disk and picks up renewals, e.g. from
+// certbot or a mounted Kubernetes secret, without a restart.
+type Reloader struct {
+	certFile string
+	keyFile  string
+
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	certMod  time.Time
+	keyMod   time.Time
+	lastStat time.Time
+}
+
+// files are checked for changes at most this often
+const statInterval = time.Second
+
+// New loads the key pair, failing if it can't so a misconfigured server
+// doesn't start.
+func New(certFile, keyFile string) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile}
+	certMod, keyMod, err := r.modTimes()
+	if err != nil {
+		return nil, err
+	}
+	err = r.load(certMod, keyMod)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Reloader) modTimes() (time.Time, time.Time, error) {
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return certInfo.ModTime(), keyInfo.ModTime(), nil
+}
+
+func (r *Reloader) load(certMod, keyMod time.Time) error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.cert = &cert
+	r.certMod = certMod
+	r.keyMod = keyMod
+	return nil
+}
+
+// GetCertificate is used as tls.Config.GetCertificate. If the files changed
+// but no longer form a valid pair, e.g. halfway through a renewal, the
+// previous certificate keeps being served.
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if time.Since(r.lastStat) < statInterval {
+		return r.cert, nil
+	}
+	r.lastStat = time.Now()
+	certMod, keyMod, err := r.modTimes()
+	if err != nil {
+		slog.Error("error checking tls certificate", "error", err)
+		return r.cert, nil
+	}
+	if certMod.Equal(r.certMod) && keyMod.Equal(r.keyMod) {
+		return r.cert, nil
+	}
+	err = r.load(certMod, keyMod)
+	if err != nil {
+		slog.Error("error reloading tls certificate", "error", err)
+		return r.cert, nil
+	}
+	slog.Info("reloaded tls certificate", "file", r.certFile)
+	return r.cert, nil
+}
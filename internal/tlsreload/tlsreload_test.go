@@ -0,0 +1,82 @@
+package tlsreload
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeKeyPair(t *testing.T, dir, commonName string, modTime time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("creating certificate: %v", err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	os.Chtimes(certFile, modTime, modTime)
+	os.Chtimes(keyFile, modTime, modTime)
+}
+
+func commonName(t *testing.T, r *Reloader) string {
+	cert, err := r.GetCertificate(nil)
+	if err != nil {
+		t.Fatalf("GetCertificate: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("parsing certificate: %v", err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	writeKeyPair(t, dir, "old", time.Now().Add(-time.Hour))
+	r, err := New(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if got := commonName(t, r); got != "old" {
+		t.Fatalf("got certificate %s, want old", got)
+	}
+
+	writeKeyPair(t, dir, "new", time.Now())
+	r.lastStat = time.Time{}
+	if got := commonName(t, r); got != "new" {
+		t.Fatalf("renewed certificate not loaded, got %s", got)
+	}
+
+	os.WriteFile(filepath.Join(dir, "cert.pem"), []byte("garbage"), 0600)
+	r.lastStat = time.Time{}
+	if got := commonName(t, r); got != "new" {
+		t.Fatalf("broken renewal replaced certificate, got %s", got)
+	}
+}
+
+func TestNewMissingFiles(t *testing.T) {
+	_, err := New("missing-cert.pem", "missing-key.pem")
+	if err == nil {
+		t.Fatalf("missing files accepted")
+	}
+}
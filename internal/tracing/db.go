@@ -0,0 +1,102 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/raffkelly/chirpy/internal/database"
+	"github.com/raffkelly/chirpy/internal/metrics"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type tracedDB struct {
+	db database.DBTX
+}
+
+// InstrumentDB starts a child span for every query run through db, named
+// after the sqlc query. Like metrics.InstrumentDB, queries made on a
+// transaction from Queries.WithTx bypass the wrapper.
+func InstrumentDB(db database.DBTX) database.DBTX {
+	return &tracedDB{db: db}
+}
+
+func (t *tracedDB) start(ctx context.Context, name, query string) (context.Context, trace.Span) {
+	return tracer().Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemPostgreSQL,
+			semconv.DBOperationName(name),
+			semconv.DBQueryText(query),
+		),
+	)
+}
+
+func end(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (t *tracedDB) Exec(ctx context.Context, query string, args ...interface{}) (pgconn.CommandTag, error) {
+	ctx, span := t.start(ctx, metrics.QueryName(query), query)
+	tag, err := t.db.Exec(ctx, query, args...)
+	end(span, err)
+	return tag, err
+}
+
+func (t *tracedDB) Query(ctx context.Context, query string, args ...interface{}) (pgx.Rows, error) {
+	ctx, span := t.start(ctx, metrics.QueryName(query), query)
+	rows, err := t.db.Query(ctx, query, args...)
+	if err != nil {
+		end(span, err)
+		return nil, err
+	}
+	return &tracedRows{Rows: rows, span: span}, nil
+}
+
+func (t *tracedDB) QueryRow(ctx context.Context, query string, args ...interface{}) pgx.Row {
+	ctx, span := t.start(ctx, metrics.QueryName(query), query)
+	return &tracedRow{row: t.db.QueryRow(ctx, query, args...), span: span}
+}
+
+func (t *tracedDB) CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error) {
+	name := metrics.CopyName(tableName)
+	ctx, span := t.start(ctx, name, "COPY "+tableName.Sanitize()+" FROM STDIN")
+	n, err := t.db.CopyFrom(ctx, tableName, columnNames, rowSrc)
+	end(span, err)
+	return n, err
+}
+
+// tracedRow ends the span on Scan, when pgx actually reads the result.
+type tracedRow struct {
+	row  pgx.Row
+	span trace.Span
+}
+
+func (r *tracedRow) Scan(dest ...any) error {
+	err := r.row.Scan(dest...)
+	end(r.span, err)
+	return err
+}
+
+// tracedRows ends the span on Close, once the rows have been read and any
+// error reading them is known.
+type tracedRows struct {
+	pgx.Rows
+	span  trace.Span
+	ended bool
+}
+
+func (r *tracedRows) Close() {
+	r.Rows.Close()
+	if !r.ended {
+		r.ended = true
+		end(r.span, r.Rows.Err())
+	}
+}
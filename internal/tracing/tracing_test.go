@@ -0,0 +1,97 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	Setup(context.Background(), "chirpy", "")
+	return recorder
+}
+
+type fakeDB struct {
+	*pgxpool.Pool
+}
+
+func (fakeDB) Exec(context.Context, string, ...interface{}) (pgconn.CommandTag, error) {
+	return pgconn.CommandTag{}, nil
+}
+
+func (fakeDB) Query(context.Context, string, ...interface{}) (pgx.Rows, error) {
+	return &failingRows{}, nil
+}
+
+// failingRows has no rows and fails as they are read, like a connection lost
+// mid-result.
+type failingRows struct {
+	pgx.Rows
+}
+
+func (*failingRows) Next() bool { return false }
+func (*failingRows) Close()     {}
+func (*failingRows) Err() error { return errors.New("connection reset") }
+
+func TestMiddlewareContinuesTrace(t *testing.T) {
+	spans := recordSpans(t)
+	db := InstrumentDB(fakeDB{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("DELETE /api/chirps/{chirpID}", func(w http.ResponseWriter, r *http.Request) {
+		db.Exec(r.Context(), "-- name: DeleteChirp :exec\nDELETE FROM chirps WHERE id = $1")
+		w.WriteHeader(http.StatusNoContent)
+	})
+	request := httptest.NewRequest("DELETE", "/api/chirps/123", nil)
+	request.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	Middleware(mux, mux).ServeHTTP(httptest.NewRecorder(), request)
+
+	ended := spans.Ended()
+	if len(ended) != 2 {
+		t.Fatalf("got %d spans, want 2", len(ended))
+	}
+	query, server := ended[0], ended[1]
+	if server.Name() != "DELETE /api/chirps/{chirpID}" {
+		t.Errorf("server span named %q", server.Name())
+	}
+	if server.SpanContext().TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("trace not continued from traceparent")
+	}
+	if query.Name() != "DeleteChirp" || query.Parent().SpanID() != server.SpanContext().SpanID() {
+		t.Errorf("query span %q not a child of the request span", query.Name())
+	}
+}
+
+func TestQuerySpanEndsOnClose(t *testing.T) {
+	spans := recordSpans(t)
+	db := InstrumentDB(fakeDB{})
+	rows, err := db.Query(context.Background(), "-- name: GetChirps :many\nSELECT * FROM chirps")
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if len(spans.Ended()) != 0 {
+		t.Fatalf("span ended before the rows were read")
+	}
+	for rows.Next() {
+	}
+	rows.Close()
+	rows.Close()
+	ended := spans.Ended()
+	if len(ended) != 1 {
+		t.Fatalf("got %d spans, want 1", len(ended))
+	}
+	if ended[0].Status().Code != codes.Error {
+		t.Errorf("error reading rows not recorded on the span")
+	}
+}
@@ -0,0 +1,70 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Headers set on outbound deliveries.
+const (
+	SignatureHeaderName = "Chirpy-Signature"
+	EventHeaderName     = "Chirpy-Event"
+	DeliveryHeaderName  = "Chirpy-Delivery"
+)
+
+const (
+	backoffBase = 30 * time.Second
+	backoffMax  = 6 * time.Hour
+)
+
+// Backoff is how long to wait before retrying after the given number of
+// failed attempts: 30s, 1m, 2m, ... doubling up to six hours.
+func Backoff(attempts int) time.Duration {
+	if attempts < 1 {
+		return 0
+	}
+	delay := backoffBase
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= backoffMax {
+			return backoffMax
+		}
+	}
+	return delay
+}
+
+// Delivery is one signed POST of an event payload to a subscriber.
+type Delivery struct {
+	ID      string
+	Event   string
+	URL     string
+	Secret  string
+	Payload []byte
+}
+
+// Send posts the delivery and returns the response status. Any non-2xx
+// status is reported as an error along with the code.
+func Send(ctx context.Context, client *http.Client, d Delivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeaderName, d.Event)
+	req.Header.Set(DeliveryHeaderName, d.ID)
+	req.Header.Set(SignatureHeaderName, SignHeader([]string{d.Secret}, time.Now(), d.Payload))
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint responded %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,54 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	cases := map[int]time.Duration{
+		0:  0,
+		1:  30 * time.Second,
+		2:  time.Minute,
+		5:  8 * time.Minute,
+		20: 6 * time.Hour,
+	}
+	for attempts, expected := range cases {
+		if got := Backoff(attempts); got != expected {
+			t.Errorf("attempt %d: got %s, want %s", attempts, got, expected)
+		}
+	}
+}
+
+func TestSend(t *testing.T) {
+	status := http.StatusNoContent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		err := Verify(r.Header.Get(SignatureHeaderName), body, []string{"whsec_test"}, time.Minute, time.Now())
+		if err != nil || r.Header.Get(EventHeaderName) != "chirp.created" || r.Header.Get(DeliveryHeaderName) != "d1" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	delivery := Delivery{ID: "d1", Event: "chirp.created", URL: server.URL, Secret: "whsec_test", Payload: []byte(`{"event":"chirp.created"}`)}
+	code, err := Send(context.Background(), server.Client(), delivery)
+	if err != nil || code != http.StatusNoContent {
+		t.Fatalf("delivery failed: %d %v", code, err)
+	}
+	status = http.StatusServiceUnavailable
+	code, err = Send(context.Background(), server.Client(), delivery)
+	if err == nil || code != http.StatusServiceUnavailable {
+		t.Fatalf("failed delivery reported as success")
+	}
+	delivery.Secret = "wrong"
+	if code, _ := Send(context.Background(), server.Client(), delivery); code != http.StatusBadRequest {
+		t.Fatalf("receiver accepted bad signature")
+	}
+}
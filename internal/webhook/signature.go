@@ -0,0 +1,89 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNoSignature        = errors.New("missing webhook signature")
+	ErrMalformedSignature = errors.New("malformed webhook signature")
+	ErrSignatureExpired   = errors.New("webhook timestamp outside tolerance")
+	ErrSignatureMismatch  = errors.New("no webhook signature matches")
+)
+
+// Sign returns the hex HMAC-SHA256 of "<timestamp>.<body>".
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignHeader builds a signature header value of the form
+// "t=<unix seconds>,v1=<hex hmac>[,v1=...]", with one v1 entry per secret so
+// keys can be rotated without downtime.
+func SignHeader(secrets []string, timestamp time.Time, body []byte) string {
+	unix := timestamp.Unix()
+	parts := []string{fmt.Sprintf("t=%d", unix)}
+	for _, secret := range secrets {
+		parts = append(parts, "v1="+Sign(secret, unix, body))
+	}
+	return strings.Join(parts, ",")
+}
+
+// Verify checks header against body. It succeeds when the timestamp is
+// within tolerance of now and any signature matches any of the secrets.
+func Verify(header string, body []byte, secrets []string, tolerance time.Duration, now time.Time) error {
+	if header == "" {
+		return ErrNoSignature
+	}
+	var timestamp int64
+	haveTimestamp := false
+	signatures := [][]byte{}
+	for _, part := range strings.Split(header, ",") {
+		key, value, found := strings.Cut(strings.TrimSpace(part), "=")
+		if !found {
+			return ErrMalformedSignature
+		}
+		switch key {
+		case "t":
+			t, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || haveTimestamp {
+				return ErrMalformedSignature
+			}
+			timestamp = t
+			haveTimestamp = true
+		case "v1":
+			sig, err := hex.DecodeString(value)
+			if err != nil {
+				return ErrMalformedSignature
+			}
+			signatures = append(signatures, sig)
+		}
+		// unknown schemes are ignored so senders can add newer ones
+	}
+	if !haveTimestamp || len(signatures) == 0 {
+		return ErrMalformedSignature
+	}
+	age := now.Sub(time.Unix(timestamp, 0))
+	if age > tolerance || age < -tolerance {
+		return ErrSignatureExpired
+	}
+	for _, secret := range secrets {
+		expected, _ := hex.DecodeString(Sign(secret, timestamp, body))
+		for _, sig := range signatures {
+			if hmac.Equal(expected, sig) {
+				return nil
+			}
+		}
+	}
+	return ErrSignatureMismatch
+}
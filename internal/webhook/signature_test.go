@@ -0,0 +1,45 @@
+package webhook
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	body := []byte(`{"id":"evt_1","event":"user.upgraded"}`)
+	now := time.Unix(1700000000, 0)
+	header := SignHeader([]string{"old-secret", "new-secret"}, now, body)
+
+	if err := Verify(header, body, []string{"new-secret"}, 5*time.Minute, now); err != nil {
+		t.Fatalf("valid signature rejected: %v", err)
+	}
+	if err := Verify(header, body, []string{"old-secret", "unused"}, 5*time.Minute, now.Add(time.Minute)); err != nil {
+		t.Fatalf("signature from rotated key rejected: %v", err)
+	}
+
+	cases := map[string]struct {
+		header string
+		body   []byte
+		now    time.Time
+		err    error
+	}{
+		"missing":       {header: "", body: body, now: now, err: ErrNoSignature},
+		"no timestamp":  {header: "v1=abcd", body: body, now: now, err: ErrMalformedSignature},
+		"bad hex":       {header: "t=1700000000,v1=zz", body: body, now: now, err: ErrMalformedSignature},
+		"no signatures": {header: "t=1700000000", body: body, now: now, err: ErrMalformedSignature},
+		"expired":       {header: header, body: body, now: now.Add(6 * time.Minute), err: ErrSignatureExpired},
+		"from future":   {header: header, body: body, now: now.Add(-6 * time.Minute), err: ErrSignatureExpired},
+		"tampered body": {header: header, body: []byte(strings.Replace(string(body), "evt_1", "evt_2", 1)), now: now, err: ErrSignatureMismatch},
+	}
+	for name, c := range cases {
+		err := Verify(c.header, c.body, []string{"new-secret"}, 5*time.Minute, c.now)
+		if !errors.Is(err, c.err) {
+			t.Errorf("%s: got %v, want %v", name, err, c.err)
+		}
+	}
+	if err := Verify(header, body, []string{"other-secret"}, 5*time.Minute, now); !errors.Is(err, ErrSignatureMismatch) {
+		t.Fatalf("signature accepted with unknown key")
+	}
+}
@@ -2,22 +2,27 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+
+	"github.com/raffkelly/chirpy/internal/auth"
+	"github.com/raffkelly/chirpy/internal/logging"
 )
 
+// respondWithError sends msg to the client. The underlying err is only
+// logged, by the access log middleware, alongside the request ID that the
+// client also receives.
 func respondWithError(w http.ResponseWriter, code int, msg string, err error) {
-	if err != nil {
-		log.Println(err)
-	}
-	if code > 499 {
-		log.Printf("Responding with 5XX error: %s", msg)
-	}
+	logging.RecordError(w, msg, err)
 	type errorResponse struct {
-		Error string `json:"error"`
+		Error     string `json:"error"`
+		RequestID string `json:"request_id,omitempty"`
 	}
 	respondWithJSON(w, code, errorResponse{
-		Error: msg,
+		Error:     msg,
+		RequestID: w.Header().Get(logging.RequestIDHeader),
 	})
 }
 
@@ -32,3 +37,30 @@ func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	w.WriteHeader(code)
 	w.Write(dat)
 }
+
+// respondWithAuthError answers a request whose credentials were missing or
+// rejected with a 401 and a WWW-Authenticate challenge for scheme. Bearer
+// challenges carry the RFC 6750 error code.
+func respondWithAuthError(w http.ResponseWriter, scheme string, err error) {
+	challenge := scheme + ` realm="chirpy"`
+	code := ""
+	msg := "invalid credentials"
+	var schemeErr *auth.SchemeError
+	switch {
+	case errors.Is(err, auth.ErrNoAuthHeader):
+		msg = "missing credentials"
+	case errors.Is(err, auth.ErrMalformedAuthHeader):
+		code = "invalid_request"
+		msg = "malformed authorization header"
+	case errors.As(err, &schemeErr):
+		code = "invalid_request"
+		msg = schemeErr.Error()
+	default:
+		code = "invalid_token"
+	}
+	if code != "" && scheme == auth.SchemeBearer {
+		challenge += fmt.Sprintf(`, error="%s"`, code)
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	respondWithError(w, http.StatusUnauthorized, msg, err)
+}
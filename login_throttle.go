@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/raffkelly/chirpy/internal/throttle"
+)
+
+var accountLoginPolicy = throttle.Policy{
+	FreeAttempts: 5,
+	BaseDelay:    time.Second,
+	MaxDelay:     15 * time.Minute,
+	Window:       time.Hour,
+}
+
+// an IP may legitimately front many users, so it gets more slack
+var ipLoginPolicy = throttle.Policy{
+	FreeAttempts: 20,
+	BaseDelay:    time.Second,
+	MaxDelay:     15 * time.Minute,
+	Window:       time.Hour,
+}
+
+// accountKey normalises an email so case variations share one counter.
+// Unknown emails are tracked too, so a lockout reveals nothing about
+// whether an account exists.
+func accountKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func (cfg *apiConfig) clientIP(r *http.Request) string {
+	if cfg.trustProxyHeaders {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// checkLoginThrottle writes a 429 and returns false while either the client
+// IP or the account is locked out.
+func (cfg *apiConfig) checkLoginThrottle(w http.ResponseWriter, r *http.Request, email string) bool {
+	now := time.Now()
+	wait := max(
+		cfg.ipLoginThrottle.Check(cfg.clientIP(r), now),
+		cfg.accountLoginThrottle.Check(accountKey(email), now),
+	)
+	if wait == 0 {
+		return true
+	}
+	cfg.metrics.Logins.WithLabelValues("throttled").Inc()
+	w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(wait.Seconds()))))
+	respondWithError(w, http.StatusTooManyRequests, "too many failed login attempts, try again later", nil)
+	return false
+}
+
+func (cfg *apiConfig) recordLoginFailure(r *http.Request, email string) {
+	cfg.metrics.Logins.WithLabelValues("failure").Inc()
+	now := time.Now()
+	cfg.ipLoginThrottle.Fail(cfg.clientIP(r), now)
+	cfg.accountLoginThrottle.Fail(accountKey(email), now)
+}
+
+// recordLoginSuccess clears the account's failures. The IP counter is left
+// alone so an attacker can't reset it by logging into their own account.
+func (cfg *apiConfig) recordLoginSuccess(email string) {
+	cfg.metrics.Logins.WithLabelValues("success").Inc()
+	cfg.accountLoginThrottle.Reset(accountKey(email))
+}
@@ -1,69 +1,325 @@
 package main
 
 import (
-	"database/sql"
+	"context"
+	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
 	"sync/atomic"
+	"syscall"
+	"time"
 
-	"github.com/joho/godotenv"
-	_ "github.com/lib/pq"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/raffkelly/chirpy/internal/auth"
+	"github.com/raffkelly/chirpy/internal/config"
 	"github.com/raffkelly/chirpy/internal/database"
+	"github.com/raffkelly/chirpy/internal/logging"
+	"github.com/raffkelly/chirpy/internal/mailer"
+	"github.com/raffkelly/chirpy/internal/metrics"
+	"github.com/raffkelly/chirpy/internal/notify"
+	"github.com/raffkelly/chirpy/internal/oidc"
+	"github.com/raffkelly/chirpy/internal/throttle"
+	"github.com/raffkelly/chirpy/internal/tracing"
 )
 
+// dbPool is the part of *pgxpool.Pool the handlers use directly, so tests
+// can stand in for Postgres.
+type dbPool interface {
+	database.DBTX
+	Begin(ctx context.Context) (pgx.Tx, error)
+	Ping(ctx context.Context) error
+}
+
 type apiConfig struct {
-	fileserverHits atomic.Int32
-	dbQueries      *database.Queries
-	platform       string
-	secret         string
-	polka_key      string
+	metrics   *metrics.Metrics
+	db        dbPool
+	dbQueries *database.Queries
+	platform  string
+	secret    string
+	// accepted Polka webhook signing secrets; more than one during rotation
+	polkaSecrets   []string
+	baseURL        string
+	mailer         mailer.Mailer
+	passwordPolicy auth.PasswordPolicy
+	// nil unless an external OpenID Connect provider is configured
+	oidcProvider         *oidc.Provider
+	webAuthn             *webauthn.WebAuthn
+	trustProxyHeaders    bool
+	accountLoginThrottle *throttle.Tracker
+	ipLoginThrottle      *throttle.Tracker
+	// actions blocked until the user has verified their email, e.g. "chirp"
+	unverifiedRestrictions map[string]bool
+	readinessTimeout       time.Duration
+	// set once shutdown starts so /readyz fails and load balancers drain
+	draining atomic.Bool
 }
 
 func main() {
+	configFile := flag.String("config", os.Getenv("CONFIG_FILE"), "YAML or TOML config file; the environment and .env take precedence")
+	printConfig := flag.Bool("print-config", false, "print the effective configuration with secrets redacted and exit")
+	flag.Usage = usage
+	flag.Parse()
+
+	conf, err := config.Load(*configFile, ".env")
+	if err != nil {
+		log.Fatalf("invalid configuration:\n%s", err)
+	}
+	if *printConfig {
+		conf.Print(os.Stdout)
+		return
+	}
+	command, args := "serve", flag.Args()
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+	if command == "migrate" {
+		err = runMigrate(conf, args)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	err = conf.Validate()
+	if err != nil {
+		log.Fatalf("invalid configuration:\n%s", err)
+	}
 
-	godotenv.Load()
-	dbURL := os.Getenv("DB_URL")
-	platform := os.Getenv("PLATFORM")
-	secret := os.Getenv("SECRET")
-	polka_key := os.Getenv("POLKA_KEY")
+	// keep stdout for command output when not serving
+	logOutput := os.Stderr
+	if command == "serve" {
+		logOutput = os.Stdout
+	}
+	logger, err := logging.New(logOutput, conf.LogFormat, conf.LogLevel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// plain log calls, e.g. from background jobs, go through the same handler
+	slog.SetDefault(logger)
 
-	db, err := sql.Open("postgres", dbURL)
+	if command == "serve" {
+		serve(conf, logger)
+		return
+	}
+	err = runCommand(conf, command, args)
 	if err != nil {
-		log.Fatalf("unable to connect to open database connection")
+		fmt.Fprintln(os.Stderr, "chirpy:", err)
+		os.Exit(1)
 	}
-	dbQueries := database.New(db)
+}
 
+// newAPIConfig builds the state shared by the HTTP handlers and the admin
+// commands, so both apply the same rules.
+func newAPIConfig(conf *config.Config, db *pgxpool.Pool) (*apiConfig, error) {
 	apiCfg := &apiConfig{}
-	apiCfg.dbQueries = dbQueries
-	apiCfg.platform = platform
-	apiCfg.secret = secret
-	apiCfg.polka_key = polka_key
+	apiCfg.metrics = metrics.New()
+	apiCfg.db = db
+	apiCfg.dbQueries = database.New(tracing.InstrumentDB(apiCfg.metrics.InstrumentDB(db)))
+	apiCfg.metrics.InstrumentPool(db)
+	apiCfg.readinessTimeout = conf.ReadinessTimeout
+	apiCfg.platform = conf.Platform
+	apiCfg.secret = conf.Secret
+	apiCfg.polkaSecrets = conf.PolkaWebhookSecrets
+	if len(apiCfg.polkaSecrets) == 0 && conf.PolkaKey != "" {
+		apiCfg.polkaSecrets = []string{conf.PolkaKey}
+	}
+	apiCfg.baseURL = strings.TrimRight(conf.BaseURL, "/")
+	apiCfg.mailer = mailer.New(
+		conf.Mailer,
+		conf.SMTPHost,
+		conf.SMTPPort,
+		conf.SMTPUsername,
+		conf.SMTPPassword,
+		conf.MailFrom,
+	)
+	apiCfg.passwordPolicy = auth.PasswordPolicy{
+		MinLength:      conf.PasswordMinLength,
+		MinEntropyBits: float64(conf.PasswordMinEntropyBits),
+	}
+	var err error
+	if conf.BreachedPasswordsFile != "" {
+		apiCfg.passwordPolicy.Breached, err = auth.LoadBreachedPasswords(conf.BreachedPasswordsFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load breached passwords: %w", err)
+		}
+	}
+	switch conf.PasswordHasher {
+	case "argon2id":
+		auth.SetHasher(auth.Argon2idHasher{
+			Memory:      uint32(conf.Argon2MemoryKiB),
+			Iterations:  uint32(conf.Argon2Iterations),
+			Parallelism: uint8(conf.Argon2Parallelism),
+			SaltLength:  auth.DefaultArgon2idHasher.SaltLength,
+			KeyLength:   auth.DefaultArgon2idHasher.KeyLength,
+		})
+	case "bcrypt":
+		auth.SetHasher(auth.BcryptHasher{Cost: conf.BcryptCost})
+	}
+	if conf.OIDCIssuer != "" {
+		redirectURL := conf.OIDCRedirectURL
+		if redirectURL == "" {
+			redirectURL = apiCfg.baseURL + "/api/oidc/callback"
+		}
+		apiCfg.oidcProvider = oidc.NewProvider(
+			conf.OIDCIssuer,
+			conf.OIDCClientID,
+			conf.OIDCClientSecret,
+			redirectURL,
+		)
+	}
+	publicURL, err := url.Parse(apiCfg.baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid BASE_URL: %w", err)
+	}
+	apiCfg.webAuthn, err = webauthn.New(&webauthn.Config{
+		RPID:          publicURL.Hostname(),
+		RPDisplayName: "Chirpy",
+		RPOrigins:     []string{apiCfg.baseURL},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to configure webauthn: %w", err)
+	}
+	apiCfg.trustProxyHeaders = conf.TrustProxyHeaders
+	apiCfg.accountLoginThrottle = throttle.NewTracker(accountLoginPolicy)
+	apiCfg.ipLoginThrottle = throttle.NewTracker(ipLoginPolicy)
+	apiCfg.unverifiedRestrictions = make(map[string]bool)
+	for _, action := range conf.UnverifiedRestrictions {
+		apiCfg.unverifiedRestrictions[action] = true
+	}
+
+	return apiCfg, nil
+}
+
+func serve(conf *config.Config, logger *slog.Logger) {
+	otlpEndpoint := conf.OTLPEndpoint
+	if conf.OTelDisabled {
+		otlpEndpoint = ""
+	}
+	shutdownTracing, err := tracing.Setup(context.Background(), conf.OTelServiceName, otlpEndpoint)
+	if err != nil {
+		log.Fatalf("unable to set up tracing: %s", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	db, err := openDB(context.Background(), conf)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = prepareSchema(context.Background(), db, conf.AutoMigrate)
+	if err != nil {
+		log.Fatal(err)
+	}
+	apiCfg, err := newAPIConfig(conf, db)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	multiplex := apiCfg.routes()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	listener := notify.NewListener(db)
+	webhookWake := listener.Wake(webhookNotifyChannel)
+	var jobs sync.WaitGroup
+	jobs.Add(3)
+	go func() {
+		defer jobs.Done()
+		listener.Run(ctx)
+	}()
+	go func() {
+		defer jobs.Done()
+		apiCfg.expireSubscriptionsEvery(ctx, conf.SubscriptionExpiryInterval)
+	}()
+	go func() {
+		defer jobs.Done()
+		apiCfg.deliverWebhooksEvery(ctx, conf.WebhookPollInterval, webhookWake)
+	}()
+
+	server, err := newServer(conf, tracing.Middleware(multiplex, middlewareQueryTimeout(conf.DBQueryTimeout, logging.Middleware(logger, apiCfg.metrics.Middleware(multiplex)))))
+	if err != nil {
+		log.Fatalf("unable to configure server: %s", err)
+	}
+	err = runServer(ctx, server, conf, func() { apiCfg.draining.Store(true) })
+	if err != nil {
+		slog.Error("server stopped", "error", err)
+	}
+	// a server that failed to start leaves the jobs running
+	stop()
+	jobs.Wait()
+	db.Close()
+}
+
+// routes registers every handler on a new ServeMux.
+func (cfg *apiConfig) routes() *http.ServeMux {
+	signedIn := authPolicy{}
+	adminOnly := authPolicy{role: auth.RoleAdmin}
+	readChirps := authPolicy{optional: true, scope: auth.ScopeChirpsRead}
+	writeChirps := authPolicy{scope: auth.ScopeChirpsWrite}
 
 	multiplex := http.NewServeMux()
 	fileServ := http.StripPrefix("/app", http.FileServer(http.Dir(".")))
-	multiplex.Handle("/app/", apiCfg.middlewareMetricsInc(fileServ))
-	multiplex.HandleFunc("GET /api/healthz", handlerReadiness)
-	multiplex.HandleFunc("GET /admin/metrics", apiCfg.handlerMetrics)
-	multiplex.HandleFunc("POST /admin/reset", apiCfg.handlerReset)
+	multiplex.Handle("/app/", cfg.middlewareMetricsInc(fileServ))
+	multiplex.HandleFunc("GET /livez", handleLiveness)
+	multiplex.HandleFunc("GET /readyz", cfg.handleReadiness)
+	// kept for probes configured before /readyz existed
+	multiplex.HandleFunc("GET /api/healthz", cfg.handleReadiness)
+	multiplex.Handle("GET /metrics", cfg.metrics.Handler())
+	multiplex.HandleFunc("POST /admin/reset", cfg.handlerReset)
+	multiplex.HandleFunc("POST /admin/users/{userID}/unlock", cfg.middlewareAuth(adminOnly, cfg.handleUnlockUser))
+	multiplex.HandleFunc("POST /admin/webhooks", cfg.middlewareAuth(adminOnly, cfg.handleCreateWebhookEndpoint))
+	multiplex.HandleFunc("GET /admin/webhooks", cfg.middlewareAuth(adminOnly, cfg.handleListWebhookEndpoints))
+	multiplex.HandleFunc("DELETE /admin/webhooks/{endpointID}", cfg.middlewareAuth(adminOnly, cfg.handleDeleteWebhookEndpoint))
+	multiplex.HandleFunc("GET /admin/webhooks/{endpointID}/deliveries", cfg.middlewareAuth(adminOnly, cfg.handleListWebhookDeliveries))
+	multiplex.HandleFunc("POST /admin/webhooks/deliveries/{deliveryID}/replay", cfg.middlewareAuth(adminOnly, cfg.handleReplayWebhookDelivery))
 	multiplex.HandleFunc("POST /api/validate_chirp", handlerValidate_Chirp)
-	multiplex.HandleFunc("POST /api/users", apiCfg.handlerCreateUser)
-	multiplex.HandleFunc("POST /api/chirps", apiCfg.handlerCreateChirp)
-	multiplex.HandleFunc("GET /api/chirps", apiCfg.handlerGetChirps)
-	multiplex.HandleFunc("GET /api/chirps/{chirpID}", apiCfg.handlerGetChirp)
-	multiplex.HandleFunc("POST /api/login", apiCfg.handlerLogin)
-	multiplex.HandleFunc("POST /api/refresh", apiCfg.handleRefresh)
-	multiplex.HandleFunc("POST /api/revoke", apiCfg.handleRevoke)
-	multiplex.HandleFunc("PUT /api/users", apiCfg.handleUpdateUser)
-	multiplex.HandleFunc("DELETE /api/chirps/{chirpID}", apiCfg.handleDeleteChirp)
-	multiplex.HandleFunc("POST /api/polka/webhooks", apiCfg.handleUpgradeUser)
-	server := http.Server{
-		Addr:    ":8080",
-		Handler: multiplex,
-	}
-	err = server.ListenAndServe()
-	if err != nil {
-		fmt.Println(err.Error())
+	multiplex.HandleFunc("POST /api/users", cfg.handlerCreateUser)
+	multiplex.HandleFunc("POST /api/chirps", cfg.middlewareAuth(writeChirps, cfg.handlerCreateChirp))
+	multiplex.HandleFunc("GET /api/chirps", cfg.middlewareAuth(readChirps, cfg.handlerGetChirps))
+	multiplex.HandleFunc("GET /api/chirps/{chirpID}", cfg.middlewareAuth(readChirps, cfg.handlerGetChirp))
+	multiplex.HandleFunc("POST /api/login", cfg.handlerLogin)
+	multiplex.HandleFunc("POST /api/login/2fa", cfg.handleLoginTwoFactor)
+	multiplex.HandleFunc("POST /api/2fa/totp", cfg.middlewareAuth(signedIn, cfg.handleEnrollTOTP))
+	multiplex.HandleFunc("POST /api/2fa/totp/confirm", cfg.middlewareAuth(signedIn, cfg.handleConfirmTOTP))
+	multiplex.HandleFunc("DELETE /api/2fa/totp", cfg.middlewareAuth(signedIn, cfg.handleDisableTOTP))
+	multiplex.HandleFunc("POST /api/2fa/recovery_codes", cfg.middlewareAuth(signedIn, cfg.handleRegenerateRecoveryCodes))
+	multiplex.HandleFunc("POST /api/refresh", cfg.handleRefresh)
+	multiplex.HandleFunc("POST /api/revoke", cfg.handleRevoke)
+	multiplex.HandleFunc("PUT /api/users", cfg.middlewareAuth(signedIn, cfg.handleUpdateUser))
+	multiplex.HandleFunc("GET /api/users/me/entitlements", cfg.middlewareAuth(signedIn, cfg.handleGetEntitlements))
+	multiplex.HandleFunc("GET /api/users/me/subscription", cfg.middlewareAuth(signedIn, cfg.handleGetSubscription))
+	multiplex.HandleFunc("PUT /api/chirps/{chirpID}", cfg.middlewareAuth(writeChirps, cfg.handleUpdateChirp))
+	multiplex.HandleFunc("DELETE /api/chirps/{chirpID}", cfg.middlewareAuth(writeChirps, cfg.handleDeleteChirp))
+	multiplex.HandleFunc("POST /api/polka/webhooks", cfg.handlePolkaWebhook)
+	multiplex.HandleFunc("POST /api/passkeys/register/begin", cfg.middlewareAuth(signedIn, cfg.handleBeginPasskeyRegistration))
+	multiplex.HandleFunc("POST /api/passkeys/register/finish", cfg.middlewareAuth(signedIn, cfg.handleFinishPasskeyRegistration))
+	multiplex.HandleFunc("POST /api/passkeys/signup/begin", cfg.handleBeginPasskeySignup)
+	multiplex.HandleFunc("POST /api/passkeys/signup/finish", cfg.handleFinishPasskeySignup)
+	multiplex.HandleFunc("POST /api/passkeys/login/begin", cfg.handleBeginPasskeyLogin)
+	multiplex.HandleFunc("POST /api/passkeys/login/finish", cfg.handleFinishPasskeyLogin)
+	multiplex.HandleFunc("GET /api/passkeys", cfg.middlewareAuth(signedIn, cfg.handleListPasskeys))
+	multiplex.HandleFunc("DELETE /api/passkeys/{passkeyID}", cfg.middlewareAuth(signedIn, cfg.handleDeletePasskey))
+	multiplex.HandleFunc("POST /api/keys", cfg.middlewareAuth(signedIn, cfg.handleCreateAPIKey))
+	multiplex.HandleFunc("GET /api/keys", cfg.middlewareAuth(signedIn, cfg.handleListAPIKeys))
+	multiplex.HandleFunc("DELETE /api/keys/{keyID}", cfg.middlewareAuth(signedIn, cfg.handleRevokeAPIKey))
+	multiplex.HandleFunc("POST /api/oauth/clients", cfg.middlewareAuth(signedIn, cfg.handleCreateOAuthClient))
+	multiplex.HandleFunc("GET /api/oauth/authorize", cfg.middlewareAuth(signedIn, cfg.handleGetAuthorization))
+	multiplex.HandleFunc("POST /api/oauth/authorize", cfg.middlewareAuth(signedIn, cfg.handleAuthorize))
+	multiplex.HandleFunc("POST /api/oauth/token", cfg.handleOAuthToken)
+	// GET serves the link in verification emails; POST is for clients that
+	// submit the token themselves
+	multiplex.HandleFunc("GET /api/email/verify", cfg.handleVerifyEmail)
+	multiplex.HandleFunc("POST /api/email/verify", cfg.handleVerifyEmail)
+	multiplex.HandleFunc("POST /api/email/verify/resend", cfg.middlewareAuth(signedIn, cfg.handleResendVerification))
+	if cfg.oidcProvider != nil {
+		multiplex.HandleFunc("GET /api/oidc/login", cfg.handleOIDCLogin)
+		multiplex.HandleFunc("GET /api/oidc/callback", cfg.handleOIDCCallback)
 	}
+	return multiplex
 }
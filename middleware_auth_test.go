@@ -0,0 +1,112 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/raffkelly/chirpy/internal/auth"
+	"github.com/raffkelly/chirpy/internal/database"
+)
+
+func TestMiddlewareAuth(t *testing.T) {
+	const secret = "middleware-auth-test-secret-0123456789"
+	regular := database.User{ID: uuid.New()}
+	admin := database.User{ID: uuid.New(), IsAdmin: true}
+	suspended := database.User{ID: uuid.New(), SuspendedAt: sql.NullTime{Time: time.Now(), Valid: true}}
+	users := map[uuid.UUID]database.User{regular.ID: regular, admin.ID: admin, suspended.ID: suspended}
+
+	personalToken, err := auth.MakePersonalAccessToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := &fakeDB{}
+	db.on("GetUser", func(args ...any) (any, error) {
+		user, ok := users[args[0].(uuid.UUID)]
+		if !ok {
+			return nil, nil
+		}
+		return user, nil
+	})
+	db.on("GetAPIKeyFromHash", func(args ...any) (any, error) {
+		if args[0] != auth.HashToken(personalToken) {
+			return nil, nil
+		}
+		return database.ApiKey{ID: uuid.New(), UserID: regular.ID, Scopes: []string{auth.ScopeChirpsWrite}}, nil
+	})
+	db.on("TouchAPIKey", func(args ...any) (any, error) { return nil, nil })
+	cfg := &apiConfig{dbQueries: database.New(db), secret: secret}
+
+	token := func(user database.User) string {
+		signed, err := auth.MakeJWT(user.ID, secret, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	oauthToken := func(user database.User, scopes ...string) string {
+		signed, err := auth.MakeScopedJWT(user.ID, "partner", scopes, secret, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	signedIn := authPolicy{}
+	adminOnly := authPolicy{role: auth.RoleAdmin}
+	readChirps := authPolicy{optional: true, scope: auth.ScopeChirpsRead}
+	writeChirps := authPolicy{scope: auth.ScopeChirpsWrite}
+
+	cases := []struct {
+		name   string
+		policy authPolicy
+		token  string
+		// status and WWW-Authenticate fragment expected; 200 means the
+		// request reached the handler
+		expected  int
+		challenge string
+		anonymous bool
+	}{
+		{"required auth without a token", signedIn, "", http.StatusUnauthorized, `Bearer realm="chirpy"`, false},
+		{"optional auth without a token", readChirps, "", http.StatusOK, "", true},
+		{"optional auth with a bad token", readChirps, "not-a-jwt", http.StatusUnauthorized, `error="invalid_token"`, false},
+		{"first-party token", signedIn, token(regular), http.StatusOK, "", false},
+		{"token for a deleted user", signedIn, token(database.User{ID: uuid.New()}), http.StatusUnauthorized, `error="invalid_token"`, false},
+		{"suspended user", signedIn, token(suspended), http.StatusForbidden, "", false},
+		{"oauth token with the scope", readChirps, oauthToken(regular, auth.ScopeChirpsRead), http.StatusOK, "", false},
+		{"oauth token missing the scope", writeChirps, oauthToken(regular, auth.ScopeChirpsRead), http.StatusForbidden, `scope="chirps:write"`, false},
+		{"oauth token on a first-party route", signedIn, oauthToken(regular, auth.ScopeChirpsRead, auth.ScopeChirpsWrite), http.StatusForbidden, `error="insufficient_scope"`, false},
+		{"personal access token with the scope", writeChirps, personalToken, http.StatusOK, "", false},
+		{"personal access token missing the scope", readChirps, personalToken, http.StatusForbidden, `scope="chirps:read"`, false},
+		{"unknown personal access token", writeChirps, "chirpy_pat_unknown", http.StatusUnauthorized, `error="invalid_token"`, false},
+		{"admin route as admin", adminOnly, token(admin), http.StatusOK, "", false},
+		{"admin route as regular user", adminOnly, token(regular), http.StatusForbidden, "", false},
+		{"admin route with an admin's oauth token", adminOnly, oauthToken(admin, auth.ScopeChirpsRead, auth.ScopeChirpsWrite), http.StatusForbidden, `error="insufficient_scope"`, false},
+	}
+	for _, c := range cases {
+		var reached auth.Principal
+		handler := cfg.middlewareAuth(c.policy, func(w http.ResponseWriter, r *http.Request) {
+			reached = currentPrincipal(r)
+			w.WriteHeader(http.StatusOK)
+		})
+		r := httptest.NewRequest("GET", "/api/chirps", nil)
+		if c.token != "" {
+			r.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		if w.Code != c.expected {
+			t.Errorf("%s: got %d, want %d", c.name, w.Code, c.expected)
+			continue
+		}
+		if !strings.Contains(w.Header().Get("WWW-Authenticate"), c.challenge) {
+			t.Errorf("%s: got challenge %q, want it to contain %q", c.name, w.Header().Get("WWW-Authenticate"), c.challenge)
+		}
+		if c.expected == http.StatusOK && (reached.UserID == uuid.Nil) != c.anonymous {
+			t.Errorf("%s: handler got principal %+v", c.name, reached)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/raffkelly/chirpy/internal/config"
+	"github.com/raffkelly/chirpy/internal/migrations"
+)
+
+// runMigrate handles "chirpy migrate ...". It only needs DB_URL, so it works
+// before the rest of the configuration is in place.
+func runMigrate(conf *config.Config, args []string) error {
+	if conf.DBURL == "" {
+		return errors.New("DB_URL is required")
+	}
+	db, err := openDB(context.Background(), conf)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	provider, err := migrations.New(db)
+	if err != nil {
+		return err
+	}
+	return migrations.Run(context.Background(), provider, args, os.Stdout)
+}
+
+// prepareSchema refuses to continue if the schema is newer than this binary,
+// then applies pending migrations when autoMigrate is set. The check comes
+// first so an old binary never touches a schema a newer release migrated. A
+// schema that is behind only gets a warning; /readyz fails until it is
+// migrated.
+func prepareSchema(ctx context.Context, db *pgxpool.Pool, autoMigrate bool) error {
+	provider, err := migrations.New(db)
+	if err != nil {
+		return err
+	}
+	version, err := migrations.CheckVersion(ctx, provider)
+	if err != nil {
+		return err
+	}
+	if autoMigrate {
+		results, err := provider.Up(ctx)
+		if err != nil {
+			return err
+		}
+		for _, result := range results {
+			slog.Info("applied migration", "version", result.Source.Version, "file", result.Source.Path, "duration", result.Duration)
+		}
+		return nil
+	}
+	if latest := migrations.Latest(); version < latest {
+		slog.Warn("database schema is behind, run chirpy migrate up", "version", version, "latest", latest)
+	}
+	return nil
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/raffkelly/chirpy/internal/auth"
+	"github.com/raffkelly/chirpy/internal/database"
+)
+
+const oauthAccessTokenTTL = time.Hour
+
+// validRedirectURI requires https, except for loopback addresses used by
+// native apps during development.
+func validRedirectURI(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || u.Fragment != "" || u.Host == "" {
+		return false
+	}
+	if u.Scheme == "https" {
+		return true
+	}
+	host := u.Hostname()
+	return u.Scheme == "http" && (host == "localhost" || host == "127.0.0.1" || host == "::1")
+}
+
+func (cfg *apiConfig) handleCreateOAuthClient(w http.ResponseWriter, r *http.Request) {
+	userID := currentPrincipal(r).UserID
+	type parameters struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Scopes       []string `json:"scopes"`
+		// public clients (SPAs, mobile apps) can't keep a secret and rely on PKCE alone
+		Confidential bool `json:"confidential"`
+	}
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "error decoding", err)
+		return
+	}
+	if params.Name == "" || len(params.RedirectURIs) == 0 {
+		respondWithError(w, http.StatusBadRequest, "name and redirect_uris are required", nil)
+		return
+	}
+	for _, redirectURI := range params.RedirectURIs {
+		if !validRedirectURI(redirectURI) {
+			respondWithError(w, http.StatusBadRequest, "redirect uris must be absolute https urls", nil)
+			return
+		}
+	}
+	for _, scope := range params.Scopes {
+		if !slices.Contains(auth.KnownScopes, scope) {
+			respondWithError(w, http.StatusBadRequest, "unknown scope "+scope, nil)
+			return
+		}
+	}
+	if len(params.Scopes) == 0 {
+		params.Scopes = auth.KnownScopes
+	}
+
+	clientID, err := auth.MakeOpaqueToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "error creating client id", err)
+		return
+	}
+	clientID = clientID[:32]
+	var clientSecret string
+	var secretHash sql.NullString
+	if params.Confidential {
+		clientSecret, err = auth.MakeOpaqueToken()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "error creating client secret", err)
+			return
+		}
+		secretHash = nullString(auth.HashToken(clientSecret))
+	}
+	clientParams := database.CreateOAuthClientParams{
+		ID:           clientID,
+		Name:         params.Name,
+		SecretHash:   secretHash,
+		RedirectUris: params.RedirectURIs,
+		Scopes:       params.Scopes,
+		OwnerID:      userID,
+	}
+	client, err := cfg.dbQueries.CreateOAuthClient(r.Context(), clientParams)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "error creating client in db", err)
+		return
+	}
+	type response struct {
+		ClientID     string   `json:"client_id"`
+		ClientSecret string   `json:"client_secret,omitempty"`
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Scopes       []string `json:"scopes"`
+	}
+	respondWithJSON(w, http.StatusCreated, response{
+		ClientID:     client.ID,
+		ClientSecret: clientSecret,
+		Name:         client.Name,
+		RedirectURIs: client.RedirectUris,
+		Scopes:       client.Scopes,
+	})
+}
+
+type authorizeParams struct {
+	ResponseType        string `json:"response_type"`
+	ClientID            string `json:"client_id"`
+	RedirectURI         string `json:"redirect_uri"`
+	Scope               string `json:"scope"`
+	State               string `json:"state"`
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"`
+}
+
+// validateAuthorizeRequest checks an authorization request against the
+// client's registration and returns the scopes being requested.
+func (cfg *apiConfig) validateAuthorizeRequest(r *http.Request, params authorizeParams) (database.OauthClient, []string, error) {
+	if params.ResponseType != "code" {
+		return database.OauthClient{}, nil, errors.New("response_type must be code")
+	}
+	client, err := cfg.dbQueries.GetOAuthClient(r.Context(), params.ClientID)
+	if err != nil {
+		return database.OauthClient{}, nil, errors.New("unknown client")
+	}
+	if !slices.Contains(client.RedirectUris, params.RedirectURI) {
+		return database.OauthClient{}, nil, errors.New("redirect_uri not registered for client")
+	}
+	if params.CodeChallenge == "" || params.CodeChallengeMethod != "S256" {
+		return database.OauthClient{}, nil, errors.New("PKCE with code_challenge_method S256 is required")
+	}
+	scopes, err := auth.ParseScopes(params.Scope, client.Scopes)
+	if err != nil {
+		return database.OauthClient{}, nil, err
+	}
+	if len(scopes) == 0 {
+		scopes = client.Scopes
+	}
+	return client, scopes, nil
+}
+
+// handleGetAuthorization describes a pending authorization request so the
+// frontend can render a consent screen.
+func (cfg *apiConfig) handleGetAuthorization(w http.ResponseWriter, r *http.Request) {
+	userID := currentPrincipal(r).UserID
+	query := r.URL.Query()
+	params := authorizeParams{
+		ResponseType:        query.Get("response_type"),
+		ClientID:            query.Get("client_id"),
+		RedirectURI:         query.Get("redirect_uri"),
+		Scope:               query.Get("scope"),
+		State:               query.Get("state"),
+		CodeChallenge:       query.Get("code_challenge"),
+		CodeChallengeMethod: query.Get("code_challenge_method"),
+	}
+	client, scopes, err := cfg.validateAuthorizeRequest(r, params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+	consentRequired := true
+	consentParams := database.GetOAuthConsentParams{
+		UserID:   userID,
+		ClientID: client.ID,
+	}
+	consent, err := cfg.dbQueries.GetOAuthConsent(r.Context(), consentParams)
+	if err == nil {
+		consentRequired = slices.ContainsFunc(scopes, func(s string) bool { return !slices.Contains(consent.Scopes, s) })
+	}
+	type response struct {
+		ClientID        string   `json:"client_id"`
+		ClientName      string   `json:"client_name"`
+		RedirectURI     string   `json:"redirect_uri"`
+		Scopes          []string `json:"scopes"`
+		ConsentRequired bool     `json:"consent_required"`
+	}
+	respondWithJSON(w, 200, response{
+		ClientID:        client.ID,
+		ClientName:      client.Name,
+		RedirectURI:     params.RedirectURI,
+		Scopes:          scopes,
+		ConsentRequired: consentRequired,
+	})
+}
+
+// handleAuthorize records the user's decision and returns where the browser
+// should be sent next, carrying either a code or an error for the client.
+func (cfg *apiConfig) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	userID := currentPrincipal(r).UserID
+	type parameters struct {
+		authorizeParams
+		Approve bool `json:"approve"`
+	}
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "error decoding", err)
+		return
+	}
+	client, scopes, err := cfg.validateAuthorizeRequest(r, params.authorizeParams)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	redirect, _ := url.Parse(params.RedirectURI)
+	query := redirect.Query()
+	if params.State != "" {
+		query.Set("state", params.State)
+	}
+	type response struct {
+		RedirectTo string `json:"redirect_to"`
+	}
+	if !params.Approve {
+		query.Set("error", "access_denied")
+		redirect.RawQuery = query.Encode()
+		respondWithJSON(w, 200, response{RedirectTo: redirect.String()})
+		return
+	}
+
+	consentParams := database.UpsertOAuthConsentParams{
+		UserID:   userID,
+		ClientID: client.ID,
+		Scopes:   scopes,
+	}
+	err = cfg.dbQueries.UpsertOAuthConsent(r.Context(), consentParams)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "error saving consent", err)
+		return
+	}
+	code, err := auth.MakeOpaqueToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "error creating authorization code", err)
+		return
+	}
+	codeParams := database.CreateAuthorizationCodeParams{
+		CodeHash:      auth.HashToken(code),
+		ClientID:      client.ID,
+		UserID:        userID,
+		RedirectUri:   params.RedirectURI,
+		Scopes:        scopes,
+		CodeChallenge: params.CodeChallenge,
+	}
+	err = cfg.dbQueries.CreateAuthorizationCode(r.Context(), codeParams)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "error saving authorization code", err)
+		return
+	}
+	query.Set("code", code)
+	redirect.RawQuery = query.Encode()
+	respondWithJSON(w, 200, response{RedirectTo: redirect.String()})
+}
+
+// respondWithOAuthError uses the RFC 6749 section 5.2 error body.
+func respondWithOAuthError(w http.ResponseWriter, code int, errorCode, description string) {
+	type errorResponse struct {
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description,omitempty"`
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, code, errorResponse{
+		Error:            errorCode,
+		ErrorDescription: description,
+	})
+}
+
+func (cfg *apiConfig) handleOAuthToken(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_request", "malformed form body")
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		respondWithOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", "")
+		return
+	}
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID = r.PostForm.Get("client_id")
+		clientSecret = r.PostForm.Get("client_secret")
+	}
+	client, err := cfg.dbQueries.GetOAuthClient(r.Context(), clientID)
+	if err != nil {
+		respondWithOAuthError(w, http.StatusUnauthorized, "invalid_client", "")
+		return
+	}
+	if client.SecretHash.Valid {
+		provided := auth.HashToken(clientSecret)
+		if subtle.ConstantTimeCompare([]byte(provided), []byte(client.SecretHash.String)) != 1 {
+			respondWithOAuthError(w, http.StatusUnauthorized, "invalid_client", "")
+			return
+		}
+	}
+
+	code, err := cfg.redeemAuthorizationCode(r.Context(), client.ID, r.PostForm.Get("code"), r.PostForm.Get("redirect_uri"), r.PostForm.Get("code_verifier"))
+	var invalid invalidGrantError
+	if errors.As(err, &invalid) {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", invalid.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "error redeeming authorization code", err)
+		return
+	}
+
+	accessToken, err := auth.MakeScopedJWT(code.UserID, client.ID, code.Scopes, cfg.secret, oauthAccessTokenTTL)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "error creating access token", err)
+		return
+	}
+	type response struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int    `json:"expires_in"`
+		Scope       string `json:"scope"`
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, 200, response{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(oauthAccessTokenTTL.Seconds()),
+		Scope:       strings.Join(code.Scopes, " "),
+	})
+}
+
+// invalidGrantError is why an authorization code was refused, reported to
+// the client as invalid_grant.
+type invalidGrantError string
+
+func (e invalidGrantError) Error() string {
+	return string(e)
+}
+
+// redeemAuthorizationCode marks a code used only once the client, redirect
+// uri and PKCE verifier all check out, so a request that fails them can't
+// burn the code for the client it was issued to.
+func (cfg *apiConfig) redeemAuthorizationCode(ctx context.Context, clientID, rawCode, redirectURI, verifier string) (database.OauthAuthorizationCode, error) {
+	tx, err := cfg.db.Begin(ctx)
+	if err != nil {
+		return database.OauthAuthorizationCode{}, err
+	}
+	defer tx.Rollback(ctx)
+	qtx := cfg.dbQueries.WithTx(tx)
+	code, err := qtx.GetAuthorizationCodeForUpdate(ctx, auth.HashToken(rawCode))
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.OauthAuthorizationCode{}, invalidGrantError("authorization code invalid, expired or already used")
+	}
+	if err != nil {
+		return database.OauthAuthorizationCode{}, err
+	}
+	if code.ClientID != clientID || code.RedirectUri != redirectURI {
+		return database.OauthAuthorizationCode{}, invalidGrantError("authorization code was issued to another client or redirect uri")
+	}
+	if !auth.VerifyPKCE(verifier, code.CodeChallenge) {
+		return database.OauthAuthorizationCode{}, invalidGrantError("code_verifier does not match code_challenge")
+	}
+	err = qtx.UseAuthorizationCode(ctx, code.CodeHash)
+	if err != nil {
+		return database.OauthAuthorizationCode{}, err
+	}
+	return code, tx.Commit(ctx)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/raffkelly/chirpy/internal/auth"
+	"github.com/raffkelly/chirpy/internal/database"
+)
+
+func TestValidRedirectURI(t *testing.T) {
+	cases := map[string]bool{
+		"https://partner.example.com/callback": true,
+		"http://localhost:3000/callback":       true,
+		"http://127.0.0.1/callback":            true,
+		"http://partner.example.com/callback":  false,
+		"https://partner.example.com/cb#frag":  false,
+		"/callback":                            false,
+		"chirpy-app:/callback":                 false,
+	}
+	for uri, expected := range cases {
+		if validRedirectURI(uri) != expected {
+			t.Errorf("redirect uri %s: expected %v", uri, expected)
+		}
+	}
+}
+
+func TestMismatchedClientLeavesCodeUsable(t *testing.T) {
+	verifier := strings.Repeat("v", 43)
+	sum := sha256.Sum256([]byte(verifier))
+	code := database.OauthAuthorizationCode{
+		CodeHash:      auth.HashToken("the-code"),
+		ClientID:      "partner",
+		UserID:        uuid.New(),
+		RedirectUri:   "https://partner.example.com/callback",
+		Scopes:        []string{auth.ScopeChirpsRead},
+		CodeChallenge: base64.RawURLEncoding.EncodeToString(sum[:]),
+	}
+	db := &fakeDB{}
+	db.on("GetOAuthClient", func(args ...any) (any, error) {
+		return database.OauthClient{ID: args[0].(string)}, nil
+	})
+	db.on("GetAuthorizationCodeForUpdate", func(args ...any) (any, error) {
+		if args[0] != code.CodeHash || code.UsedAt.Valid {
+			return nil, nil
+		}
+		return code, nil
+	})
+	db.on("UseAuthorizationCode", func(args ...any) (any, error) {
+		code.UsedAt = sql.NullTime{Time: time.Now(), Valid: true}
+		return nil, nil
+	})
+	cfg := &apiConfig{
+		db:        db,
+		dbQueries: database.New(db),
+		secret:    "oauth-test-secret-0123456789abcdef",
+	}
+	exchange := func(clientID, redirectURI, verifier string) int {
+		form := url.Values{
+			"grant_type":    {"authorization_code"},
+			"client_id":     {clientID},
+			"code":          {"the-code"},
+			"redirect_uri":  {redirectURI},
+			"code_verifier": {verifier},
+		}
+		r := httptest.NewRequest("POST", "/oauth/token", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		cfg.handleOAuthToken(w, r)
+		return w.Code
+	}
+
+	refused := []struct {
+		name        string
+		clientID    string
+		redirectURI string
+		verifier    string
+	}{
+		{"another client", "attacker", code.RedirectUri, verifier},
+		{"another redirect uri", "partner", "https://attacker.example.com/callback", verifier},
+		{"wrong verifier", "partner", code.RedirectUri, strings.Repeat("x", 43)},
+	}
+	for _, c := range refused {
+		if status := exchange(c.clientID, c.redirectURI, c.verifier); status != http.StatusBadRequest {
+			t.Errorf("%s: got %d, want 400", c.name, status)
+		}
+	}
+	if db.called("UseAuthorizationCode") {
+		t.Fatalf("a refused exchange marked the code used")
+	}
+	if status := exchange("partner", code.RedirectUri, verifier); status != http.StatusOK {
+		t.Fatalf("rightful client: got %d, want 200", status)
+	}
+	if status := exchange("partner", code.RedirectUri, verifier); status != http.StatusBadRequest {
+		t.Fatalf("replayed code: got %d, want 400", status)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/raffkelly/chirpy/internal/auth"
+	"github.com/raffkelly/chirpy/internal/database"
+	"github.com/raffkelly/chirpy/internal/oidc"
+)
+
+const (
+	oidcStateCookie = "chirpy_oidc_state"
+	oidcStateTTL    = 10 * time.Minute
+)
+
+// handleOIDCLogin redirects the browser to the external identity provider.
+func (cfg *apiConfig) handleOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	state, err := auth.NewOIDCState()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "error creating login state", err)
+		return
+	}
+	authURL, err := cfg.oidcProvider.AuthCodeURL(r.Context(), state.State, state.Nonce, state.CodeChallenge())
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "identity provider unavailable", err)
+		return
+	}
+	sealed, err := auth.SealOIDCState(state, cfg.secret, oidcStateTTL)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "error creating login state", err)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    sealed,
+		Path:     "/api/oidc/",
+		MaxAge:   int(oidcStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(cfg.baseURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// handleOIDCCallback completes the provider login and then continues exactly
+// like a password login.
+func (cfg *apiConfig) handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "login state missing or expired", err)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/api/oidc/", MaxAge: -1})
+	state, err := auth.OpenOIDCState(cookie.Value, cfg.secret)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "login state missing or expired", err)
+		return
+	}
+	query := r.URL.Query()
+	if subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(state.State)) != 1 {
+		respondWithError(w, http.StatusBadRequest, "login state mismatch", nil)
+		return
+	}
+	if query.Get("error") != "" {
+		respondWithError(w, http.StatusUnauthorized, "identity provider denied login: "+query.Get("error"), nil)
+		return
+	}
+	claims, err := cfg.oidcProvider.Exchange(r.Context(), query.Get("code"), state.CodeVerifier, state.Nonce)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "identity provider login failed", err)
+		return
+	}
+	user, err := cfg.userForOIDCIdentity(r, claims)
+	if errors.Is(err, errOIDCEmailTaken) {
+		respondWithError(w, http.StatusConflict, err.Error(), nil)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "error linking identity", err)
+		return
+	}
+	cfg.completeLogin(w, r, user)
+}
+
+var errOIDCEmailTaken = errors.New("an account with this email already exists; log in with your password first")
+
+// userForOIDCIdentity finds the user linked to the provider identity. New
+// identities are linked to an existing user only when both the provider and
+// the local account have verified the email; otherwise a fresh passwordless
+// account is created.
+func (cfg *apiConfig) userForOIDCIdentity(r *http.Request, claims oidc.Claims) (database.User, error) {
+	identityParams := database.GetOIDCIdentityParams{
+		Issuer:  cfg.oidcProvider.Issuer(),
+		Subject: claims.Subject,
+	}
+	identity, err := cfg.dbQueries.GetOIDCIdentity(r.Context(), identityParams)
+	if err == nil {
+		return cfg.dbQueries.GetUser(r.Context(), identity.UserID)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return database.User{}, err
+	}
+	if claims.Email == "" {
+		return database.User{}, errors.New("identity provider did not share an email")
+	}
+
+	user, err := cfg.dbQueries.GetUserFromEmail(r.Context(), claims.Email)
+	if err == nil && !canLinkOIDCIdentity(user, claims) {
+		return database.User{}, errOIDCEmailTaken
+	}
+	created := errors.Is(err, sql.ErrNoRows)
+	if err != nil && !created {
+		return database.User{}, err
+	}
+
+	tx, err := cfg.db.Begin(r.Context())
+	if err != nil {
+		return database.User{}, err
+	}
+	defer tx.Rollback(r.Context())
+	qtx := cfg.dbQueries.WithTx(tx)
+	queued := false
+	if created {
+		// the account is only as verified as the provider says the email is
+		externalParams := database.CreateExternalUserParams{
+			Email:         claims.Email,
+			EmailVerified: claims.EmailVerified,
+		}
+		user, err = qtx.CreateExternalUser(r.Context(), externalParams)
+		if err != nil {
+			return database.User{}, err
+		}
+		queued, err = queueUserCreated(r.Context(), qtx, user)
+		if err != nil {
+			return database.User{}, err
+		}
+	}
+	createParams := database.CreateOIDCIdentityParams{
+		Issuer:  cfg.oidcProvider.Issuer(),
+		Subject: claims.Subject,
+		UserID:  user.ID,
+		Email:   claims.Email,
+	}
+	err = qtx.CreateOIDCIdentity(r.Context(), createParams)
+	if err != nil {
+		return database.User{}, err
+	}
+	err = tx.Commit(r.Context())
+	if err != nil {
+		return database.User{}, err
+	}
+	if created {
+		cfg.announceUserCreated(r.Context(), queued)
+	}
+	return user, nil
+}
+
+// canLinkOIDCIdentity reports whether a new provider identity may take over
+// the existing account with the same email. An unverified local account may
+// belong to someone who signed up with another person's address, so linking
+// it would hand them that person's OIDC logins.
+func canLinkOIDCIdentity(user database.User, claims oidc.Claims) bool {
+	return claims.EmailVerified && user.EmailVerified
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/raffkelly/chirpy/internal/database"
+	"github.com/raffkelly/chirpy/internal/metrics"
+	"github.com/raffkelly/chirpy/internal/oidc"
+)
+
+func TestCanLinkOIDCIdentity(t *testing.T) {
+	cases := []struct {
+		name          string
+		localVerified bool
+		idpVerified   bool
+		expected      bool
+	}{
+		{"both verified", true, true, true},
+		{"unverified local account", false, true, false},
+		{"unverified provider email", true, false, false},
+	}
+	for _, c := range cases {
+		user := database.User{EmailVerified: c.localVerified}
+		claims := oidc.Claims{Email: "walt@example.com", EmailVerified: c.idpVerified}
+		if got := canLinkOIDCIdentity(user, claims); got != c.expected {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.expected)
+		}
+	}
+}
+
+func TestUnverifiedOIDCEmailCreatesUnverifiedAccount(t *testing.T) {
+	db := &fakeDB{}
+	var created database.User
+	db.on("GetOIDCIdentity", func(args ...any) (any, error) { return nil, nil })
+	db.on("GetUserFromEmail", func(args ...any) (any, error) { return nil, nil })
+	db.on("CreateExternalUser", func(args ...any) (any, error) {
+		created = database.User{ID: uuid.New(), Email: args[0].(string), EmailVerified: args[1].(bool)}
+		return created, nil
+	})
+	db.on("EnqueueWebhookEvent", func(args ...any) (any, error) { return int64(0), nil })
+	db.on("CreateOIDCIdentity", func(args ...any) (any, error) { return nil, nil })
+	cfg := &apiConfig{
+		metrics:      metrics.New(),
+		db:           db,
+		dbQueries:    database.New(db),
+		oidcProvider: oidc.NewProvider("https://idp.example.com", "chirpy", "secret", "http://localhost:8080/api/oidc/callback"),
+	}
+
+	claims := oidc.Claims{Email: "walt@example.com", EmailVerified: false}
+	claims.Subject = "attacker"
+	user, err := cfg.userForOIDCIdentity(httptest.NewRequest("GET", "/api/oidc/callback", nil), claims)
+	if err != nil {
+		t.Fatalf("creating account: %v", err)
+	}
+	if user.EmailVerified || created.EmailVerified {
+		t.Fatalf("account from an unverified provider email was marked verified")
+	}
+	// the victim later signing in with a verified identity must not get linked
+	verified := oidc.Claims{Email: "walt@example.com", EmailVerified: true}
+	if canLinkOIDCIdentity(user, verified) {
+		t.Fatalf("verified identity would be linked into the unverified account")
+	}
+}
@@ -0,0 +1,510 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/raffkelly/chirpy/internal/database"
+)
+
+// unsetPassword is the hashed_password placeholder from migration 003 for
+// accounts that have never had a password, such as passkey-only accounts.
+const unsetPassword = "unset"
+
+type Passkey struct {
+	ID             uuid.UUID  `json:"id"`
+	CreatedAt      time.Time  `json:"created_at"`
+	Name           string     `json:"name"`
+	Transports     []string   `json:"transports"`
+	BackupEligible bool       `json:"backup_eligible"`
+	BackupState    bool       `json:"backup_state"`
+	LastUsedAt     *time.Time `json:"last_used_at"`
+}
+
+func passkeyFromDB(passkey database.Passkey) Passkey {
+	return Passkey{
+		ID:             passkey.ID,
+		CreatedAt:      passkey.CreatedAt,
+		Name:           passkey.Name,
+		Transports:     passkey.Transports,
+		BackupEligible: passkey.BackupEligible,
+		BackupState:    passkey.BackupState,
+		LastUsedAt:     nullTimePtr(passkey.LastUsedAt),
+	}
+}
+
+// webAuthnUser adapts a user and their stored passkeys to webauthn.User.
+type webAuthnUser struct {
+	user     database.User
+	passkeys []database.Passkey
+}
+
+func (u webAuthnUser) WebAuthnID() []byte {
+	return u.user.ID[:]
+}
+
+func (u webAuthnUser) WebAuthnName() string {
+	return u.user.Email
+}
+
+func (u webAuthnUser) WebAuthnDisplayName() string {
+	return u.user.Email
+}
+
+func (u webAuthnUser) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, len(u.passkeys))
+	for i, passkey := range u.passkeys {
+		transports := make([]protocol.AuthenticatorTransport, len(passkey.Transports))
+		for j, transport := range passkey.Transports {
+			transports[j] = protocol.AuthenticatorTransport(transport)
+		}
+		credentials[i] = webauthn.Credential{
+			ID:              passkey.CredentialID,
+			PublicKey:       passkey.PublicKey,
+			AttestationType: passkey.AttestationType,
+			Transport:       transports,
+			Flags: webauthn.CredentialFlags{
+				BackupEligible: passkey.BackupEligible,
+				BackupState:    passkey.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    passkey.Aaguid,
+				SignCount: uint32(passkey.SignCount),
+			},
+		}
+	}
+	return credentials
+}
+
+func (cfg *apiConfig) loadWebAuthnUser(ctx context.Context, userID uuid.UUID) (webAuthnUser, error) {
+	user, err := cfg.dbQueries.GetUser(ctx, userID)
+	if err != nil {
+		return webAuthnUser{}, err
+	}
+	passkeys, err := cfg.dbQueries.GetPasskeysByUserID(ctx, userID)
+	if err != nil {
+		return webAuthnUser{}, err
+	}
+	return webAuthnUser{user: user, passkeys: passkeys}, nil
+}
+
+// saveWebAuthnSession stores ceremony state server side so each challenge can
+// only be answered once. A passkey signup has no user yet and keeps the
+// email it will create the account with instead.
+func (cfg *apiConfig) saveWebAuthnSession(ctx context.Context, userID uuid.NullUUID, signupEmail sql.NullString, session *webauthn.SessionData) (uuid.UUID, error) {
+	err := cfg.dbQueries.DeleteExpiredWebAuthnSessions(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "error deleting expired webauthn sessions", "error", err)
+	}
+	data, err := json.Marshal(session)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	sessionParams := database.CreateWebAuthnSessionParams{
+		UserID: userID,
+		Email:  signupEmail,
+		Data:   data,
+	}
+	saved, err := cfg.dbQueries.CreateWebAuthnSession(ctx, sessionParams)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return saved.ID, nil
+}
+
+func (cfg *apiConfig) takeWebAuthnSession(ctx context.Context, sessionID uuid.UUID, userID uuid.NullUUID) (webauthn.SessionData, error) {
+	saved, err := cfg.dbQueries.TakeWebAuthnSession(ctx, sessionID)
+	if err != nil {
+		return webauthn.SessionData{}, errors.New("unknown or expired session")
+	}
+	if saved.UserID != userID || saved.Email.Valid {
+		return webauthn.SessionData{}, errors.New("session belongs to another user")
+	}
+	session := webauthn.SessionData{}
+	err = json.Unmarshal(saved.Data, &session)
+	return session, err
+}
+
+func (cfg *apiConfig) handleBeginPasskeyRegistration(w http.ResponseWriter, r *http.Request) {
+	userID := currentPrincipal(r).UserID
+	user, err := cfg.loadWebAuthnUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "user not found", err)
+		return
+	}
+	cfg.beginPasskeyRegistration(w, r, user, uuid.NullUUID{UUID: userID, Valid: true}, sql.NullString{})
+}
+
+func (cfg *apiConfig) handleFinishPasskeyRegistration(w http.ResponseWriter, r *http.Request) {
+	userID := currentPrincipal(r).UserID
+	type parameters struct {
+		SessionID  uuid.UUID       `json:"session_id"`
+		Name       string          `json:"name"`
+		Credential json.RawMessage `json:"credential"`
+	}
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "error decoding", err)
+		return
+	}
+	session, err := cfg.takeWebAuthnSession(r.Context(), params.SessionID, uuid.NullUUID{UUID: userID, Valid: true})
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	user, err := cfg.loadWebAuthnUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "user not found", err)
+		return
+	}
+	passkey, err := cfg.createPasskey(r.Context(), user, session, params.Name, params.Credential)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "passkey registration failed", err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, passkeyFromDB(passkey))
+}
+
+// errPasskeyUnverified rejects authenticators that skipped the PIN or
+// biometric check. Passkey logins bypass TOTP, so they must not be a single
+// factor.
+var errPasskeyUnverified = errors.New("passkey did not verify the user")
+
+func (cfg *apiConfig) createPasskey(ctx context.Context, user webAuthnUser, session webauthn.SessionData, name string, rawCredential []byte) (database.Passkey, error) {
+	credential, err := cfg.verifyPasskeyRegistration(user, session, rawCredential)
+	if err != nil {
+		return database.Passkey{}, err
+	}
+	return cfg.dbQueries.CreatePasskey(ctx, newPasskeyParams(user.user.ID, name, credential))
+}
+
+// verifyPasskeyRegistration checks an attestation against its session
+// without storing anything.
+func (cfg *apiConfig) verifyPasskeyRegistration(user webAuthnUser, session webauthn.SessionData, rawCredential []byte) (*webauthn.Credential, error) {
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(rawCredential)
+	if err != nil {
+		return nil, err
+	}
+	credential, err := cfg.webAuthn.CreateCredential(user, session, parsed)
+	if err != nil {
+		return nil, err
+	}
+	if !credential.Flags.UserVerified {
+		return nil, errPasskeyUnverified
+	}
+	return credential, nil
+}
+
+func newPasskeyParams(userID uuid.UUID, name string, credential *webauthn.Credential) database.CreatePasskeyParams {
+	if name == "" {
+		name = "Passkey"
+	}
+	transports := make([]string, len(credential.Transport))
+	for i, transport := range credential.Transport {
+		transports[i] = string(transport)
+	}
+	return database.CreatePasskeyParams{
+		UserID:          userID,
+		Name:            name,
+		CredentialID:    credential.ID,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		Aaguid:          credential.Authenticator.AAGUID,
+		SignCount:       int64(credential.Authenticator.SignCount),
+		Transports:      transports,
+		BackupEligible:  credential.Flags.BackupEligible,
+		BackupState:     credential.Flags.BackupState,
+	}
+}
+
+func (cfg *apiConfig) beginPasskeyRegistration(w http.ResponseWriter, r *http.Request, user webAuthnUser, sessionUserID uuid.NullUUID, signupEmail sql.NullString) {
+	exclusions := make([]protocol.CredentialDescriptor, len(user.passkeys))
+	for i, credential := range user.WebAuthnCredentials() {
+		exclusions[i] = credential.Descriptor()
+	}
+	creation, session, err := cfg.webAuthn.BeginRegistration(
+		user,
+		webauthn.WithExclusions(exclusions),
+		webauthn.WithAuthenticatorSelection(protocol.AuthenticatorSelection{
+			ResidentKey:        protocol.ResidentKeyRequirementRequired,
+			RequireResidentKey: protocol.ResidentKeyRequired(),
+			UserVerification:   protocol.VerificationRequired,
+		}),
+	)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "error starting passkey registration", err)
+		return
+	}
+	sessionID, err := cfg.saveWebAuthnSession(r.Context(), sessionUserID, signupEmail, session)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "error saving passkey session", err)
+		return
+	}
+	type response struct {
+		SessionID uuid.UUID                    `json:"session_id"`
+		Options   *protocol.CredentialCreation `json:"options"`
+	}
+	respondWithJSON(w, 200, response{SessionID: sessionID, Options: creation})
+}
+
+// handleBeginPasskeySignup starts registering the first passkey of a
+// passwordless account. The account itself is only created once that passkey
+// is registered, so abandoned signups leave nothing behind.
+func (cfg *apiConfig) handleBeginPasskeySignup(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+	}
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "error decoding", err)
+		return
+	}
+	if params.Email == "" || (!strings.Contains(params.Email, "@")) {
+		respondWithError(w, http.StatusBadRequest, "provided email improper", nil)
+		return
+	}
+	_, err = cfg.dbQueries.GetUserFromEmail(r.Context(), params.Email)
+	if err == nil {
+		respondWithError(w, http.StatusConflict, "an account with this email already exists", nil)
+		return
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusInternalServerError, "unable to find user", err)
+		return
+	}
+	// the ID becomes the passkey's user handle, so the account must be
+	// created with it
+	pending := webAuthnUser{user: database.User{ID: uuid.New(), Email: params.Email}}
+	cfg.beginPasskeyRegistration(w, r, pending, uuid.NullUUID{}, sql.NullString{String: params.Email, Valid: true})
+}
+
+func (cfg *apiConfig) handleFinishPasskeySignup(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		SessionID  uuid.UUID       `json:"session_id"`
+		Name       string          `json:"name"`
+		Credential json.RawMessage `json:"credential"`
+	}
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "error decoding", err)
+		return
+	}
+	saved, err := cfg.dbQueries.TakeWebAuthnSession(r.Context(), params.SessionID)
+	if err != nil || saved.UserID.Valid || !saved.Email.Valid {
+		respondWithError(w, http.StatusBadRequest, "unknown or expired session", err)
+		return
+	}
+	session := webauthn.SessionData{}
+	err = json.Unmarshal(saved.Data, &session)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "error reading passkey session", err)
+		return
+	}
+	userID, err := uuid.FromBytes(session.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "error reading passkey session", err)
+		return
+	}
+	pending := webAuthnUser{user: database.User{ID: userID, Email: saved.Email.String}}
+	credential, err := cfg.verifyPasskeyRegistration(pending, session, params.Credential)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "passkey registration failed", err)
+		return
+	}
+	user, err := cfg.createPasskeyUser(r.Context(), pending.user, params.Name, credential)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			respondWithError(w, http.StatusConflict, "an account with this email already exists", err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "error creating user in database", err)
+		return
+	}
+	err = cfg.sendVerificationEmail(r.Context(), user.ID, user.Email)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error sending verification email", "error", err)
+	}
+	cfg.respondWithSession(w, r, user)
+}
+
+// createPasskeyUser creates a passwordless account together with its first
+// passkey and its webhook event, so none exists without the others.
+func (cfg *apiConfig) createPasskeyUser(ctx context.Context, pending database.User, name string, credential *webauthn.Credential) (database.User, error) {
+	tx, err := cfg.db.Begin(ctx)
+	if err != nil {
+		return database.User{}, err
+	}
+	defer tx.Rollback(ctx)
+	qtx := cfg.dbQueries.WithTx(tx)
+	userParams := database.CreateUserWithIDParams{
+		ID:             pending.ID,
+		Email:          pending.Email,
+		HashedPassword: unsetPassword,
+	}
+	user, err := qtx.CreateUserWithID(ctx, userParams)
+	if err != nil {
+		return database.User{}, err
+	}
+	_, err = qtx.CreatePasskey(ctx, newPasskeyParams(user.ID, name, credential))
+	if err != nil {
+		return database.User{}, err
+	}
+	queued, err := queueUserCreated(ctx, qtx, user)
+	if err != nil {
+		return database.User{}, err
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		return database.User{}, err
+	}
+	cfg.announceUserCreated(ctx, queued)
+	return user, nil
+}
+
+// handleBeginPasskeyLogin starts a discoverable login, so the client doesn't
+// need to know the email up front.
+func (cfg *apiConfig) handleBeginPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	assertion, session, err := cfg.webAuthn.BeginDiscoverableLogin(
+		webauthn.WithUserVerification(protocol.VerificationRequired),
+	)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "error starting passkey login", err)
+		return
+	}
+	sessionID, err := cfg.saveWebAuthnSession(r.Context(), uuid.NullUUID{}, sql.NullString{}, session)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "error saving passkey session", err)
+		return
+	}
+	type response struct {
+		SessionID uuid.UUID                     `json:"session_id"`
+		Options   *protocol.CredentialAssertion `json:"options"`
+	}
+	respondWithJSON(w, 200, response{SessionID: sessionID, Options: assertion})
+}
+
+func (cfg *apiConfig) handleFinishPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		SessionID  uuid.UUID       `json:"session_id"`
+		Credential json.RawMessage `json:"credential"`
+	}
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "error decoding", err)
+		return
+	}
+	session, err := cfg.takeWebAuthnSession(r.Context(), params.SessionID, uuid.NullUUID{})
+	if err != nil {
+		respondWithError(w, 401, err.Error(), err)
+		return
+	}
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(params.Credential)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "malformed credential", err)
+		return
+	}
+	var loggedIn webAuthnUser
+	findUser := func(rawID, userHandle []byte) (webauthn.User, error) {
+		userID, err := uuid.FromBytes(userHandle)
+		if err != nil {
+			return nil, err
+		}
+		loggedIn, err = cfg.loadWebAuthnUser(r.Context(), userID)
+		return loggedIn, err
+	}
+	credential, err := cfg.webAuthn.ValidateDiscoverableLogin(findUser, session, parsed)
+	if err != nil {
+		respondWithError(w, 401, "passkey login failed", err)
+		return
+	}
+	// the PIN or biometric check stands in for TOTP, so it is mandatory
+	if !credential.Flags.UserVerified {
+		respondWithError(w, 401, "passkey login failed", errPasskeyUnverified)
+		return
+	}
+	// a sign count that went backwards suggests the authenticator was cloned
+	if credential.Authenticator.CloneWarning {
+		respondWithError(w, 401, "passkey login failed", errors.New("authenticator clone warning"))
+		return
+	}
+	updateParams := database.UpdatePasskeyAfterLoginParams{
+		SignCount:    int64(credential.Authenticator.SignCount),
+		BackupState:  credential.Flags.BackupState,
+		CredentialID: credential.ID,
+	}
+	err = cfg.dbQueries.UpdatePasskeyAfterLogin(r.Context(), updateParams)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "error updating passkey", err)
+		return
+	}
+	// a passkey with user verification already proves possession and a PIN
+	// or biometric, so no TOTP challenge follows
+	cfg.recordLoginSuccess(loggedIn.user.Email)
+	cfg.respondWithSession(w, r, loggedIn.user)
+}
+
+func (cfg *apiConfig) handleListPasskeys(w http.ResponseWriter, r *http.Request) {
+	userID := currentPrincipal(r).UserID
+	passkeys, err := cfg.dbQueries.GetPasskeysByUserID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "error retrieving passkeys from db", err)
+		return
+	}
+	responsePasskeys := make([]Passkey, len(passkeys))
+	for i, passkey := range passkeys {
+		responsePasskeys[i] = passkeyFromDB(passkey)
+	}
+	respondWithJSON(w, 200, responsePasskeys)
+}
+
+func (cfg *apiConfig) handleDeletePasskey(w http.ResponseWriter, r *http.Request) {
+	userID := currentPrincipal(r).UserID
+	passkeyID, err := uuid.Parse(r.PathValue("passkeyID"))
+	if err != nil {
+		respondWithError(w, 404, "error parsing passkey id", err)
+		return
+	}
+	user, err := cfg.loadWebAuthnUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "user not found", err)
+		return
+	}
+	// passwordless accounts would be locked out without their last passkey
+	if user.user.HashedPassword == unsetPassword && len(user.passkeys) == 1 && user.passkeys[0].ID == passkeyID {
+		respondWithError(w, http.StatusConflict, "set a password before removing your last passkey", nil)
+		return
+	}
+	deleteParams := database.DeletePasskeyParams{
+		ID:     passkeyID,
+		UserID: userID,
+	}
+	rows, err := cfg.dbQueries.DeletePasskey(r.Context(), deleteParams)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "error deleting passkey", err)
+		return
+	}
+	if rows == 0 {
+		respondWithError(w, 404, "passkey not found", nil)
+		return
+	}
+	respondWithJSON(w, 204, nil)
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/raffkelly/chirpy/internal/auth"
+	"github.com/raffkelly/chirpy/internal/database"
+	"github.com/raffkelly/chirpy/internal/metrics"
+	"github.com/raffkelly/chirpy/internal/throttle"
+)
+
+const passkeyTestOrigin = "http://localhost:8080"
+
+// softAuthenticator is a platform authenticator in software: it answers
+// registration with "none" attestation and signs assertions with an ES256
+// key, always reporting user presence and verification.
+type softAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentialID := make([]byte, 16)
+	rand.Read(credentialID)
+	return &softAuthenticator{key: key, credentialID: credentialID}
+}
+
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttestedData = 0x40
+)
+
+func (a *softAuthenticator) authenticatorData(t *testing.T, flags byte, counter uint32) []byte {
+	t.Helper()
+	rpIDHash := sha256.Sum256([]byte("localhost"))
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, counter)
+	if flags&flagAttestedData == 0 {
+		return data
+	}
+	publicKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  int64(webauthncose.P256),
+		XCoord: a.key.PublicKey.X.FillBytes(make([]byte, 32)),
+		YCoord: a.key.PublicKey.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = append(data, make([]byte, 16)...) // AAGUID
+	data = binary.BigEndian.AppendUint16(data, uint16(len(a.credentialID)))
+	data = append(data, a.credentialID...)
+	return append(data, publicKey...)
+}
+
+func clientData(t *testing.T, ceremony, challenge string) []byte {
+	t.Helper()
+	data, err := json.Marshal(map[string]string{
+		"type":      ceremony,
+		"challenge": challenge,
+		"origin":    passkeyTestOrigin,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// register answers navigator.credentials.create() for the given challenge.
+func (a *softAuthenticator) register(t *testing.T, challenge string) json.RawMessage {
+	t.Helper()
+	attestation, err := webauthncbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": a.authenticatorData(t, flagUserPresent|flagUserVerified|flagAttestedData, 0),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	credential, _ := json.Marshal(map[string]any{
+		"id":    b64(a.credentialID),
+		"rawId": b64(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]any{
+			"clientDataJSON":    b64(clientData(t, "webauthn.create", challenge)),
+			"attestationObject": b64(attestation),
+			"transports":        []string{"internal"},
+		},
+	})
+	return credential
+}
+
+// login answers navigator.credentials.get() for the given challenge.
+func (a *softAuthenticator) login(t *testing.T, challenge string, userID uuid.UUID) json.RawMessage {
+	t.Helper()
+	authData := a.authenticatorData(t, flagUserPresent|flagUserVerified, 1)
+	client := clientData(t, "webauthn.get", challenge)
+	clientHash := sha256.Sum256(client)
+	digest := sha256.Sum256(append(slices.Clone(authData), clientHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	credential, _ := json.Marshal(map[string]any{
+		"id":    b64(a.credentialID),
+		"rawId": b64(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]any{
+			"clientDataJSON":    b64(client),
+			"authenticatorData": b64(authData),
+			"signature":         b64(signature),
+			"userHandle":        b64(userID[:]),
+		},
+	})
+	return credential
+}
+
+// passkeyStore backs the passkey queries with maps, enough for the
+// signup, login and deletion flows.
+type passkeyStore struct {
+	users    map[uuid.UUID]database.User
+	passkeys []database.Passkey
+	sessions map[uuid.UUID]database.WebauthnSession
+}
+
+func newPasskeyTestConfig(t *testing.T) (*apiConfig, *passkeyStore, *fakeDB) {
+	t.Helper()
+	store := &passkeyStore{
+		users:    make(map[uuid.UUID]database.User),
+		sessions: make(map[uuid.UUID]database.WebauthnSession),
+	}
+	db := &fakeDB{}
+	db.on("DeleteExpiredWebAuthnSessions", func(args ...any) (any, error) { return nil, nil })
+	db.on("CreateWebAuthnSession", func(args ...any) (any, error) {
+		saved := database.WebauthnSession{
+			ID:     uuid.New(),
+			UserID: args[0].(uuid.NullUUID),
+			Email:  args[1].(sql.NullString),
+			Data:   args[2].([]byte),
+		}
+		store.sessions[saved.ID] = saved
+		return saved, nil
+	})
+	db.on("TakeWebAuthnSession", func(args ...any) (any, error) {
+		saved, ok := store.sessions[args[0].(uuid.UUID)]
+		if !ok {
+			return nil, nil
+		}
+		delete(store.sessions, saved.ID)
+		return saved, nil
+	})
+	db.on("GetUserFromEmail", func(args ...any) (any, error) {
+		for _, user := range store.users {
+			if user.Email == args[0].(string) {
+				return user, nil
+			}
+		}
+		return nil, nil
+	})
+	db.on("GetUser", func(args ...any) (any, error) {
+		user, ok := store.users[args[0].(uuid.UUID)]
+		if !ok {
+			return nil, nil
+		}
+		return user, nil
+	})
+	db.on("CreateUserWithID", func(args ...any) (any, error) {
+		for _, user := range store.users {
+			if user.Email == args[1].(string) {
+				return nil, &pgconn.PgError{Code: "23505"}
+			}
+		}
+		user := database.User{ID: args[0].(uuid.UUID), Email: args[1].(string), HashedPassword: args[2].(string)}
+		store.users[user.ID] = user
+		return user, nil
+	})
+	db.on("CreatePasskey", func(args ...any) (any, error) {
+		passkey := database.Passkey{
+			ID:              uuid.New(),
+			UserID:          args[0].(uuid.UUID),
+			Name:            args[1].(string),
+			CredentialID:    args[2].([]byte),
+			PublicKey:       args[3].([]byte),
+			AttestationType: args[4].(string),
+			Aaguid:          args[5].([]byte),
+			SignCount:       args[6].(int64),
+			Transports:      args[7].([]string),
+			BackupEligible:  args[8].(bool),
+			BackupState:     args[9].(bool),
+		}
+		store.passkeys = append(store.passkeys, passkey)
+		return passkey, nil
+	})
+	db.on("GetPasskeysByUserID", func(args ...any) (any, error) {
+		var passkeys []database.Passkey
+		for _, passkey := range store.passkeys {
+			if passkey.UserID == args[0].(uuid.UUID) {
+				passkeys = append(passkeys, passkey)
+			}
+		}
+		return passkeys, nil
+	})
+	db.on("UpdatePasskeyAfterLogin", func(args ...any) (any, error) { return nil, nil })
+	db.on("DeletePasskey", func(args ...any) (any, error) {
+		before := len(store.passkeys)
+		store.passkeys = slices.DeleteFunc(store.passkeys, func(passkey database.Passkey) bool {
+			return passkey.ID == args[0].(uuid.UUID) && passkey.UserID == args[1].(uuid.UUID)
+		})
+		return int64(before - len(store.passkeys)), nil
+	})
+	db.on("CreateRefreshToken", func(args ...any) (any, error) {
+		return database.RefreshToken{Token: args[0].(string), UserID: args[1].(uuid.UUID)}, nil
+	})
+	db.on("EnqueueWebhookEvent", func(args ...any) (any, error) { return int64(0), nil })
+
+	relyingParty, err := webauthn.New(&webauthn.Config{
+		RPID:          "localhost",
+		RPDisplayName: "Chirpy",
+		RPOrigins:     []string{passkeyTestOrigin},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &apiConfig{
+		metrics:              metrics.New(),
+		db:                   db,
+		dbQueries:            database.New(db),
+		secret:               "passkey-test-secret-0123456789abcdef",
+		baseURL:              passkeyTestOrigin,
+		mailer:               &recordingMailer{},
+		webAuthn:             relyingParty,
+		accountLoginThrottle: throttle.NewTracker(accountLoginPolicy),
+		ipLoginThrottle:      throttle.NewTracker(ipLoginPolicy),
+	}
+	return cfg, store, db
+}
+
+func postJSON(t *testing.T, handler http.HandlerFunc, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("POST", "/api/passkeys", bytes.NewReader(data)))
+	return w
+}
+
+type beganCeremony struct {
+	SessionID uuid.UUID `json:"session_id"`
+	Options   struct {
+		PublicKey struct {
+			Challenge string `json:"challenge"`
+		} `json:"publicKey"`
+	} `json:"options"`
+}
+
+func beginCeremony(t *testing.T, handler http.HandlerFunc, body any) beganCeremony {
+	t.Helper()
+	w := postJSON(t, handler, body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("begin: got %d: %s", w.Code, w.Body)
+	}
+	began := beganCeremony{}
+	err := json.NewDecoder(w.Body).Decode(&began)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return began
+}
+
+func finishSignup(t *testing.T, cfg *apiConfig, began beganCeremony, authenticator *softAuthenticator) *httptest.ResponseRecorder {
+	t.Helper()
+	return postJSON(t, cfg.handleFinishPasskeySignup, map[string]any{
+		"session_id": began.SessionID,
+		"credential": authenticator.register(t, began.Options.PublicKey.Challenge),
+	})
+}
+
+func TestPasskeySignupAndLogin(t *testing.T) {
+	cfg, store, db := newPasskeyTestConfig(t)
+	authenticator := newSoftAuthenticator(t)
+
+	began := beginCeremony(t, cfg.handleBeginPasskeySignup, map[string]string{"email": "walt@example.com"})
+	if len(store.users) != 0 {
+		t.Fatalf("account created before any passkey was registered")
+	}
+	w := finishSignup(t, cfg, began, authenticator)
+	if w.Code != http.StatusOK {
+		t.Fatalf("finish signup: got %d: %s", w.Code, w.Body)
+	}
+	if !db.called("Commit") || len(store.users) != 1 || len(store.passkeys) != 1 {
+		t.Fatalf("signup did not create the account with its passkey")
+	}
+	signedUp := User{}
+	json.NewDecoder(w.Body).Decode(&signedUp)
+	if signedUp.Token == "" || store.users[signedUp.ID].HashedPassword != unsetPassword {
+		t.Fatalf("signup did not sign in to a passwordless account: %+v", signedUp)
+	}
+
+	began = beginCeremony(t, cfg.handleBeginPasskeyLogin, nil)
+	w = postJSON(t, cfg.handleFinishPasskeyLogin, map[string]any{
+		"session_id": began.SessionID,
+		"credential": authenticator.login(t, began.Options.PublicKey.Challenge, signedUp.ID),
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("login: got %d: %s", w.Code, w.Body)
+	}
+	loggedIn := User{}
+	json.NewDecoder(w.Body).Decode(&loggedIn)
+	if loggedIn.ID != signedUp.ID || loggedIn.Token == "" {
+		t.Fatalf("login returned %+v, want a session for %s", loggedIn, signedUp.ID)
+	}
+}
+
+func TestPasskeySignupCanBeRetried(t *testing.T) {
+	cfg, store, _ := newPasskeyTestConfig(t)
+
+	abandoned := beginCeremony(t, cfg.handleBeginPasskeySignup, map[string]string{"email": "walt@example.com"})
+	retried := beginCeremony(t, cfg.handleBeginPasskeySignup, map[string]string{"email": "walt@example.com"})
+	if len(store.users) != 0 {
+		t.Fatalf("abandoned signup left an account behind")
+	}
+	w := finishSignup(t, cfg, retried, newSoftAuthenticator(t))
+	if w.Code != http.StatusOK {
+		t.Fatalf("retried signup: got %d: %s", w.Code, w.Body)
+	}
+
+	// the first attempt can no longer claim the email
+	w = finishSignup(t, cfg, abandoned, newSoftAuthenticator(t))
+	if w.Code != http.StatusConflict {
+		t.Fatalf("finishing the abandoned signup: got %d, want 409", w.Code)
+	}
+	w = postJSON(t, cfg.handleBeginPasskeySignup, map[string]string{"email": "walt@example.com"})
+	if w.Code != http.StatusConflict {
+		t.Fatalf("signup for a taken email: got %d, want 409", w.Code)
+	}
+	if len(store.users) != 1 || len(store.passkeys) != 1 {
+		t.Fatalf("got %d users and %d passkeys, want one of each", len(store.users), len(store.passkeys))
+	}
+}
+
+func TestDeleteLastPasskey(t *testing.T) {
+	cases := []struct {
+		name           string
+		hashedPassword string
+		expected       int
+	}{
+		{"passwordless account", unsetPassword, http.StatusConflict},
+		{"account with a password", "$argon2id$hash", http.StatusNoContent},
+	}
+	for _, c := range cases {
+		cfg, store, _ := newPasskeyTestConfig(t)
+		user := database.User{ID: uuid.New(), Email: "walt@example.com", HashedPassword: c.hashedPassword}
+		passkey := database.Passkey{ID: uuid.New(), UserID: user.ID}
+		store.users[user.ID] = user
+		store.passkeys = []database.Passkey{passkey}
+
+		r := httptest.NewRequest("DELETE", "/api/passkeys/"+passkey.ID.String(), nil)
+		r.SetPathValue("passkeyID", passkey.ID.String())
+		r = r.WithContext(auth.WithPrincipal(r.Context(), auth.Principal{AccessClaims: auth.AccessClaims{UserID: user.ID}}))
+		w := httptest.NewRecorder()
+		cfg.handleDeletePasskey(w, r)
+		if w.Code != c.expected {
+			t.Errorf("%s: got %d, want %d", c.name, w.Code, c.expected)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/raffkelly/chirpy/internal/database"
+	"github.com/raffkelly/chirpy/internal/webhook"
+)
+
+const (
+	polkaSignatureHeader    = "Polka-Signature"
+	polkaSignatureTolerance = 5 * time.Minute
+	maxWebhookBodyBytes     = 1 << 20
+)
+
+type polkaEvent struct {
+	ID    string            `json:"id"`
+	Event string            `json:"event"`
+	Data  map[string]string `json:"data"`
+}
+
+// handlePolkaWebhook receives Polka's subscription events. Each request must
+// be signed with one of the configured secrets, and events are recorded by ID
+// so retries are acknowledged without being applied twice.
+func (cfg *apiConfig) handlePolkaWebhook(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBodyBytes))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "error reading request body", err)
+		return
+	}
+	err = webhook.Verify(r.Header.Get(polkaSignatureHeader), body, cfg.polkaSecrets, polkaSignatureTolerance, time.Now())
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "invalid webhook signature", err)
+		return
+	}
+	params := polkaEvent{}
+	err = json.Unmarshal(body, &params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "error decoding request body", err)
+		return
+	}
+	if params.ID == "" {
+		respondWithError(w, http.StatusBadRequest, "event id required", nil)
+		return
+	}
+	err = cfg.applyPolkaEvent(r.Context(), params)
+	if errors.Is(err, errPolkaBadPayload) {
+		respondWithError(w, http.StatusBadRequest, "malformed event data", err)
+		return
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+		respondWithError(w, 404, "user not found", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "error processing event", err)
+		return
+	}
+	respondWithJSON(w, 204, nil)
+}
+
+var errPolkaBadPayload = errors.New("malformed polka event data")
+
+// applyPolkaEvent records the event and applies it in one transaction, so a
+// failed attempt leaves nothing behind and Polka's retry is processed again.
+// Events seen before are a no-op.
+func (cfg *apiConfig) applyPolkaEvent(ctx context.Context, event polkaEvent) error {
+	tx, err := cfg.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	qtx := cfg.dbQueries.WithTx(tx)
+	eventParams := database.RecordPolkaEventParams{
+		ID:    event.ID,
+		Event: event.Event,
+	}
+	inserted, err := qtx.RecordPolkaEvent(ctx, eventParams)
+	if err != nil {
+		return err
+	}
+	if inserted == 0 {
+		return nil
+	}
+	err = applySubscriptionEvent(ctx, qtx, event)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+	cfg.metrics.PolkaEvents.WithLabelValues(event.Event).Inc()
+	return nil
+}
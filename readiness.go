@@ -1,9 +1,88 @@
 package main
 
-import "net/http"
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
 
-func handlerReadiness(w http.ResponseWriter, r *http.Request) {
-	w.Header().Add("Content-Type", "text/plain; charset=utf-8")
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(http.StatusText(http.StatusOK)))
+	"github.com/raffkelly/chirpy/internal/migrations"
+)
+
+type healthCheck struct {
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+	Duration string `json:"duration"`
+	Error    string `json:"error,omitempty"`
+}
+
+type healthReport struct {
+	Status string        `json:"status"`
+	Checks []healthCheck `json:"checks,omitempty"`
+}
+
+// handleLiveness only reports that the process is serving requests. It
+// doesn't touch the database, so an outage there doesn't get every instance
+// restarted.
+func handleLiveness(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, http.StatusOK, healthReport{Status: "ok"})
+}
+
+// handleReadiness reports whether this instance should receive traffic:
+// Postgres answers within the readiness timeout, the schema is migrated at
+// least to the version this binary expects, and the server isn't shutting
+// down.
+func (cfg *apiConfig) handleReadiness(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), cfg.readinessTimeout)
+	defer cancel()
+
+	report := healthReport{Status: "ok"}
+	run := func(name string, check func(context.Context) error) {
+		start := time.Now()
+		err := check(ctx)
+		result := healthCheck{Name: name, Status: "ok", Duration: time.Since(start).String()}
+		if err != nil {
+			result.Status = "fail"
+			result.Error = err.Error()
+			report.Status = "fail"
+		}
+		report.Checks = append(report.Checks, result)
+	}
+	run("shutdown", func(context.Context) error {
+		if cfg.draining.Load() {
+			return fmt.Errorf("server is shutting down")
+		}
+		return nil
+	})
+	run("database", cfg.db.Ping)
+	run("migrations", cfg.checkSchemaVersion)
+
+	status := http.StatusOK
+	if report.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	respondWithJSON(w, status, report)
+}
+
+// checkSchemaVersion reads the version goose last applied. The table isn't
+// part of sql/schema, so sqlc doesn't know about it.
+func (cfg *apiConfig) checkSchemaVersion(ctx context.Context) error {
+	var version int64
+	err := cfg.db.QueryRow(ctx,
+		"SELECT version_id FROM goose_db_version WHERE is_applied ORDER BY id DESC LIMIT 1",
+	).Scan(&version)
+	if err != nil {
+		return fmt.Errorf("reading schema version: %w", err)
+	}
+	return schemaReady(version)
+}
+
+// schemaReady fails only for a schema behind this binary. A newer one is fine:
+// during a rolling deploy the first new replica migrates while old ones keep
+// serving, and startup already rejects schemas this binary can't work with.
+func schemaReady(version int64) error {
+	if latest := migrations.Latest(); version < latest {
+		return fmt.Errorf("schema at version %d, want %d", version, latest)
+	}
+	return nil
 }
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/raffkelly/chirpy/internal/migrations"
+)
+
+func TestReadinessReportsFailingChecks(t *testing.T) {
+	// nothing listens on port 1, so the ping fails straight away
+	db, err := pgxpool.New(context.Background(), "postgres://chirpy@127.0.0.1:1/chirpy?sslmode=disable")
+	if err != nil {
+		t.Fatalf("creating pool: %v", err)
+	}
+	defer db.Close()
+	cfg := &apiConfig{db: db, readinessTimeout: time.Second}
+	cfg.draining.Store(true)
+
+	recorder := httptest.NewRecorder()
+	cfg.handleReadiness(recorder, httptest.NewRequest("GET", "/readyz", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Fatalf("got status %d, want 503", recorder.Code)
+	}
+	report := healthReport{}
+	json.NewDecoder(recorder.Body).Decode(&report)
+	if report.Status != "fail" || len(report.Checks) != 3 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	for _, check := range report.Checks {
+		if check.Status != "fail" || check.Error == "" {
+			t.Errorf("check %s should fail with a reason, got %+v", check.Name, check)
+		}
+	}
+}
+
+func TestSchemaReady(t *testing.T) {
+	latest := migrations.Latest()
+	if schemaReady(latest-1) == nil {
+		t.Errorf("schema behind the binary reported ready")
+	}
+	if err := schemaReady(latest); err != nil {
+		t.Errorf("current schema: %v", err)
+	}
+	// a newer release migrated during a rolling deploy
+	if err := schemaReady(latest + 1); err != nil {
+		t.Errorf("newer schema: %v", err)
+	}
+}
@@ -8,8 +8,7 @@ func (cfg *apiConfig) handlerReset(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("Reset is only allowed in dev environment."))
 		return
 	}
-	cfg.fileserverHits.Store(0)
 	cfg.dbQueries.DeleteUsers(r.Context())
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("Hits reset to 0 and users database cleared"))
+	w.Write([]byte("Users database cleared"))
 }
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/raffkelly/chirpy/internal/config"
+	"github.com/raffkelly/chirpy/internal/tlsreload"
+)
+
+// newServer builds the http.Server. Timeouts guard against slow clients
+// holding connections open.
+func newServer(conf *config.Config, handler http.Handler) (*http.Server, error) {
+	server := &http.Server{
+		Addr:              conf.Addr,
+		Handler:           handler,
+		ReadHeaderTimeout: conf.HTTPReadHeaderTimeout,
+		ReadTimeout:       conf.HTTPReadTimeout,
+		WriteTimeout:      conf.HTTPWriteTimeout,
+		IdleTimeout:       conf.HTTPIdleTimeout,
+		MaxHeaderBytes:    conf.HTTPMaxHeaderBytes,
+		ErrorLog:          slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
+	}
+	if conf.TLSCertFile == "" {
+		return server, nil
+	}
+	reloader, err := tlsreload.New(conf.TLSCertFile, conf.TLSKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	server.TLSConfig = &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+	return server, nil
+}
+
+// runServer serves until ctx is done. It then calls drain, keeps serving for
+// SHUTDOWN_DRAIN_DELAY so load balancers see readiness fail and stop sending
+// traffic, and finally stops accepting connections and waits up to
+// SHUTDOWN_TIMEOUT for in-flight requests to finish.
+func runServer(ctx context.Context, server *http.Server, conf *config.Config, drain func()) error {
+	serveErr := make(chan error, 1)
+	go func() {
+		slog.Info("listening", "addr", server.Addr, "tls", server.TLSConfig != nil)
+		if server.TLSConfig != nil {
+			// certificates come from TLSConfig.GetCertificate
+			serveErr <- server.ListenAndServeTLS("", "")
+		} else {
+			serveErr <- server.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+	drain()
+	slog.Info("shutting down, draining traffic", "delay", conf.ShutdownDrainDelay, "timeout", conf.ShutdownTimeout)
+	select {
+	case err := <-serveErr:
+		return err
+	case <-time.After(conf.ShutdownDrainDelay):
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), conf.ShutdownTimeout)
+	defer cancel()
+	err := server.Shutdown(shutdownCtx)
+	if err != nil {
+		// deadline passed; cut off whatever is still running
+		server.Close()
+		return err
+	}
+	return nil
+}
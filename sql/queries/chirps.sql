@@ -24,4 +24,18 @@ WHERE id = $1;
 -- name: GetChripsByUserID :many
 SELECT * FROM chirps
 WHERE user_id = $1
-ORDER BY created_at;
\ No newline at end of file
+ORDER BY created_at;
+-- name: UpdateChirp :one
+UPDATE chirps
+SET body = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: CountChirpsByUserSince :one
+SELECT COUNT(*) FROM chirps
+WHERE user_id = $1
+AND created_at > $2;
+
+-- name: CopyChirps :copyfrom
+INSERT INTO chirps (id, created_at, updated_at, body, user_id)
+VALUES ($1, $2, $3, $4, $5);
@@ -0,0 +1,3 @@
+-- name: Notify :exec
+-- Delivered to listeners when the surrounding transaction commits.
+SELECT pg_notify(sqlc.arg(channel)::text, sqlc.arg(payload)::text);
@@ -0,0 +1,13 @@
+-- name: GetOIDCIdentity :one
+SELECT * FROM oidc_identities
+WHERE issuer = $1 AND subject = $2;
+
+-- name: CreateOIDCIdentity :exec
+INSERT INTO oidc_identities (issuer, subject, created_at, user_id, email)
+VALUES (
+    $1,
+    $2,
+    NOW(),
+    $3,
+    $4
+);
@@ -0,0 +1,53 @@
+-- name: CreatePasskey :one
+INSERT INTO passkeys (id, created_at, updated_at, user_id, name, credential_id, public_key, attestation_type, aaguid, sign_count, transports, backup_eligible, backup_state)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    $7,
+    $8,
+    $9,
+    $10
+)
+RETURNING *;
+
+-- name: GetPasskeysByUserID :many
+SELECT * FROM passkeys
+WHERE user_id = $1
+ORDER BY created_at;
+
+-- name: UpdatePasskeyAfterLogin :exec
+UPDATE passkeys
+SET sign_count = $1, backup_state = $2, last_used_at = NOW(), updated_at = NOW()
+WHERE credential_id = $3;
+
+-- name: DeletePasskey :execrows
+DELETE FROM passkeys
+WHERE id = $1 AND user_id = $2;
+
+-- name: CreateWebAuthnSession :one
+INSERT INTO webauthn_sessions (id, created_at, user_id, email, data, expires_at)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    NOW() + INTERVAL '5 minutes'
+)
+RETURNING *;
+
+-- name: TakeWebAuthnSession :one
+DELETE FROM webauthn_sessions
+WHERE id = $1 AND expires_at > NOW()
+RETURNING *;
+
+-- name: DeleteExpiredWebAuthnSessions :exec
+DELETE FROM webauthn_sessions
+WHERE expires_at <= NOW();
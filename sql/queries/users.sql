@@ -9,6 +9,17 @@ VALUES (
 )
 RETURNING *;
 
+-- name: CreateUserWithID :one
+INSERT INTO users (id, created_at, updated_at, email, hashed_password)
+VALUES (
+    $1,
+    NOW(),
+    NOW(),
+    $2,
+    $3
+)
+RETURNING *;
+
 -- name: DeleteUsers :exec
 DELETE FROM users;
 
@@ -25,4 +36,76 @@ RETURNING *;
 -- name: UpgradeUser :exec
 UPDATE users
 SET is_chirpy_red = true
-WHERE ID = $1;
\ No newline at end of file
+WHERE ID = $1;
+
+-- name: GetUser :one
+SELECT * FROM users
+WHERE id = $1;
+
+-- name: UpdateUserPassword :one
+UPDATE users
+SET hashed_password = $1, updated_at = NOW()
+WHERE id = $2
+RETURNING *;
+
+-- name: SetUserPendingEmail :one
+UPDATE users
+SET pending_email = $1, updated_at = NOW()
+WHERE id = $2
+RETURNING *;
+
+-- name: VerifyUserEmail :one
+UPDATE users
+SET email = $1, email_verified = true, pending_email = NULL, updated_at = NOW()
+WHERE id = $2
+RETURNING *;
+
+-- name: SetUserTOTPSecret :exec
+UPDATE users
+SET totp_secret = $1, totp_enabled = false, totp_last_step = 0, updated_at = NOW()
+WHERE id = $2;
+
+-- name: EnableUserTOTP :exec
+UPDATE users
+SET totp_enabled = true, updated_at = NOW()
+WHERE id = $1;
+
+-- name: DisableUserTOTP :exec
+UPDATE users
+SET totp_secret = NULL, totp_enabled = false, totp_last_step = 0, updated_at = NOW()
+WHERE id = $1;
+
+-- name: UseTOTPStep :execrows
+UPDATE users
+SET totp_last_step = $1
+WHERE id = $2 AND totp_last_step < $1;
+
+-- name: CreateExternalUser :one
+INSERT INTO users (id, created_at, updated_at, email, email_verified)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    $2
+)
+RETURNING *;
+
+-- name: SetUserChirpyRed :exec
+UPDATE users
+SET is_chirpy_red = $2, updated_at = NOW()
+WHERE id = $1;
+
+-- name: ListUsers :many
+SELECT * FROM users
+ORDER BY created_at;
+
+-- name: SetUserAdmin :exec
+UPDATE users
+SET is_admin = $2, updated_at = NOW()
+WHERE id = $1;
+
+-- name: SetUserSuspended :exec
+UPDATE users
+SET suspended_at = $2, updated_at = NOW()
+WHERE id = $1;
@@ -0,0 +1,72 @@
+-- name: CreateWebhookEndpoint :one
+INSERT INTO webhook_endpoints (id, created_at, updated_at, url, secret, events, active)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    true
+)
+RETURNING *;
+
+-- name: GetWebhookEndpoints :many
+SELECT * FROM webhook_endpoints
+ORDER BY created_at;
+
+-- name: GetWebhookEndpoint :one
+SELECT * FROM webhook_endpoints
+WHERE id = $1;
+
+-- name: DeleteWebhookEndpoint :execrows
+DELETE FROM webhook_endpoints
+WHERE id = $1;
+
+-- name: EnqueueWebhookEvent :execrows
+INSERT INTO webhook_deliveries (id, created_at, updated_at, endpoint_id, event_id, event, payload, status, next_attempt_at)
+SELECT gen_random_uuid(), NOW(), NOW(), id, $1, $2, $3, 'pending', NOW()
+FROM webhook_endpoints
+WHERE active AND ($2 = ANY(events) OR '*' = ANY(events));
+
+-- name: ClaimWebhookDeliveries :many
+-- Pushes next_attempt_at forward as a lease so other workers skip the
+-- claimed rows while they are being sent. The claim token identifies the
+-- lease holder when the attempt is recorded.
+UPDATE webhook_deliveries
+SET next_attempt_at = NOW() + make_interval(secs => sqlc.arg(lease_seconds)),
+    claim_token = sqlc.arg(claim_token)::uuid,
+    updated_at = NOW()
+WHERE id IN (
+    SELECT id FROM webhook_deliveries
+    WHERE status = 'pending' AND next_attempt_at <= NOW()
+    ORDER BY next_attempt_at
+    LIMIT sqlc.arg(batch_size)
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+
+-- name: RecordWebhookAttempt :execrows
+-- Matches nothing if the lease expired and another worker claimed the row.
+UPDATE webhook_deliveries
+SET status = $2,
+    attempts = attempts + 1,
+    next_attempt_at = $3,
+    last_attempt_at = NOW(),
+    last_status_code = $4,
+    last_error = $5,
+    claim_token = NULL,
+    updated_at = NOW()
+WHERE id = $1 AND claim_token = sqlc.arg(claim_token);
+
+-- name: GetWebhookDeliveriesByEndpoint :many
+SELECT * FROM webhook_deliveries
+WHERE endpoint_id = $1
+ORDER BY created_at DESC
+LIMIT $2;
+
+-- name: ReplayWebhookDelivery :one
+UPDATE webhook_deliveries
+SET status = 'pending', attempts = 0, next_attempt_at = NOW(), updated_at = NOW()
+WHERE id = $1 AND status = 'dead'
+RETURNING *;
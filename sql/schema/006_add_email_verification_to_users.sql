@@ -0,0 +1,12 @@
+-- +goose Up
+ALTER TABLE users
+ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT false,
+ADD COLUMN pending_email TEXT;
+
+UPDATE users
+SET email_verified = true;
+
+-- +goose Down
+ALTER TABLE users
+DROP COLUMN email_verified,
+DROP COLUMN pending_email;
@@ -0,0 +1,35 @@
+-- +goose Up
+CREATE TABLE subscriptions (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    user_id UUID NOT NULL UNIQUE,
+    status TEXT NOT NULL,
+    -- NULL for members upgraded before subscriptions were tracked; they never expire
+    current_period_end TIMESTAMP,
+    FOREIGN KEY (user_id)
+    REFERENCES users(id)
+    ON DELETE CASCADE
+);
+
+CREATE TABLE subscription_events (
+    id UUID PRIMARY KEY,
+    subscription_id UUID NOT NULL,
+    polka_event_id TEXT NOT NULL,
+    event TEXT NOT NULL,
+    status TEXT NOT NULL,
+    current_period_end TIMESTAMP,
+    created_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (subscription_id)
+    REFERENCES subscriptions(id)
+    ON DELETE CASCADE
+);
+
+INSERT INTO subscriptions (id, created_at, updated_at, user_id, status)
+SELECT gen_random_uuid(), NOW(), NOW(), id, 'active'
+FROM users
+WHERE is_chirpy_red;
+
+-- +goose Down
+DROP TABLE subscription_events;
+DROP TABLE subscriptions;
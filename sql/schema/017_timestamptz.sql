@@ -0,0 +1,148 @@
+-- +goose Up
+-- existing values came from NOW() cast to the session time zone, so they are
+-- read back in that zone rather than assumed to be UTC
+ALTER TABLE users
+ALTER COLUMN created_at TYPE TIMESTAMPTZ USING created_at AT TIME ZONE current_setting('TimeZone'),
+ALTER COLUMN updated_at TYPE TIMESTAMPTZ USING updated_at AT TIME ZONE current_setting('TimeZone'),
+ALTER COLUMN suspended_at TYPE TIMESTAMPTZ USING suspended_at AT TIME ZONE current_setting('TimeZone');
+
+ALTER TABLE chirps
+ALTER COLUMN created_at TYPE TIMESTAMPTZ USING created_at AT TIME ZONE current_setting('TimeZone'),
+ALTER COLUMN updated_at TYPE TIMESTAMPTZ USING updated_at AT TIME ZONE current_setting('TimeZone');
+
+ALTER TABLE refresh_tokens
+ALTER COLUMN created_at TYPE TIMESTAMPTZ USING created_at AT TIME ZONE current_setting('TimeZone'),
+ALTER COLUMN updated_at TYPE TIMESTAMPTZ USING updated_at AT TIME ZONE current_setting('TimeZone'),
+ALTER COLUMN expires_at TYPE TIMESTAMPTZ USING expires_at AT TIME ZONE current_setting('TimeZone'),
+ALTER COLUMN revoked_at TYPE TIMESTAMPTZ USING revoked_at AT TIME ZONE current_setting('TimeZone');
+
+ALTER TABLE recovery_codes
+ALTER COLUMN created_at TYPE TIMESTAMPTZ USING created_at AT TIME ZONE current_setting('TimeZone'),
+ALTER COLUMN used_at TYPE TIMESTAMPTZ USING used_at AT TIME ZONE current_setting('TimeZone');
+
+ALTER TABLE oauth_clients
+ALTER COLUMN created_at TYPE TIMESTAMPTZ USING created_at AT TIME ZONE current_setting('TimeZone'),
+ALTER COLUMN updated_at TYPE TIMESTAMPTZ USING updated_at AT TIME ZONE current_setting('TimeZone');
+
+ALTER TABLE oauth_authorization_codes
+ALTER COLUMN created_at TYPE TIMESTAMPTZ USING created_at AT TIME ZONE current_setting('TimeZone'),
+ALTER COLUMN expires_at TYPE TIMESTAMPTZ USING expires_at AT TIME ZONE current_setting('TimeZone'),
+ALTER COLUMN used_at TYPE TIMESTAMPTZ USING used_at AT TIME ZONE current_setting('TimeZone');
+
+ALTER TABLE oauth_consents
+ALTER COLUMN created_at TYPE TIMESTAMPTZ USING created_at AT TIME ZONE current_setting('TimeZone'),
+ALTER COLUMN updated_at TYPE TIMESTAMPTZ USING updated_at AT TIME ZONE current_setting('TimeZone');
+
+ALTER TABLE api_keys
+ALTER COLUMN created_at TYPE TIMESTAMPTZ USING created_at AT TIME ZONE current_setting('TimeZone'),
+ALTER COLUMN expires_at TYPE TIMESTAMPTZ USING expires_at AT TIME ZONE current_setting('TimeZone'),
+ALTER COLUMN last_used_at TYPE TIMESTAMPTZ USING last_used_at AT TIME ZONE current_setting('TimeZone'),
+ALTER COLUMN revoked_at TYPE TIMESTAMPTZ USING revoked_at AT TIME ZONE current_setting('TimeZone');
+
+ALTER TABLE oidc_identities
+ALTER COLUMN created_at TYPE TIMESTAMPTZ USING created_at AT TIME ZONE current_setting('TimeZone');
+
+ALTER TABLE passkeys
+ALTER COLUMN created_at TYPE TIMESTAMPTZ USING created_at AT TIME ZONE current_setting('TimeZone'),
+ALTER COLUMN updated_at TYPE TIMESTAMPTZ USING updated_at AT TIME ZONE current_setting('TimeZone'),
+ALTER COLUMN last_used_at TYPE TIMESTAMPTZ USING last_used_at AT TIME ZONE current_setting('TimeZone');
+
+ALTER TABLE webauthn_sessions
+ALTER COLUMN created_at TYPE TIMESTAMPTZ USING created_at AT TIME ZONE current_setting('TimeZone'),
+ALTER COLUMN expires_at TYPE TIMESTAMPTZ USING expires_at AT TIME ZONE current_setting('TimeZone');
+
+ALTER TABLE polka_events
+ALTER COLUMN received_at TYPE TIMESTAMPTZ USING received_at AT TIME ZONE current_setting('TimeZone');
+
+ALTER TABLE subscriptions
+ALTER COLUMN created_at TYPE TIMESTAMPTZ USING created_at AT TIME ZONE current_setting('TimeZone'),
+ALTER COLUMN updated_at TYPE TIMESTAMPTZ USING updated_at AT TIME ZONE current_setting('TimeZone'),
+ALTER COLUMN current_period_end TYPE TIMESTAMPTZ USING current_period_end AT TIME ZONE current_setting('TimeZone');
+
+ALTER TABLE subscription_events
+ALTER COLUMN current_period_end TYPE TIMESTAMPTZ USING current_period_end AT TIME ZONE current_setting('TimeZone'),
+ALTER COLUMN created_at TYPE TIMESTAMPTZ USING created_at AT TIME ZONE current_setting('TimeZone');
+
+ALTER TABLE webhook_endpoints
+ALTER COLUMN created_at TYPE TIMESTAMPTZ USING created_at AT TIME ZONE current_setting('TimeZone'),
+ALTER COLUMN updated_at TYPE TIMESTAMPTZ USING updated_at AT TIME ZONE current_setting('TimeZone');
+
+ALTER TABLE webhook_deliveries
+ALTER COLUMN created_at TYPE TIMESTAMPTZ USING created_at AT TIME ZONE current_setting('TimeZone'),
+ALTER COLUMN updated_at TYPE TIMESTAMPTZ USING updated_at AT TIME ZONE current_setting('TimeZone'),
+ALTER COLUMN next_attempt_at TYPE TIMESTAMPTZ USING next_attempt_at AT TIME ZONE current_setting('TimeZone'),
+ALTER COLUMN last_attempt_at TYPE TIMESTAMPTZ USING last_attempt_at AT TIME ZONE current_setting('TimeZone');
+
+-- +goose Down
+-- back to wall-clock times in the session time zone, as NOW() stored them
+ALTER TABLE users
+ALTER COLUMN created_at TYPE TIMESTAMP USING created_at AT TIME ZONE current_setting('TimeZone'),
+ALTER COLUMN updated_at TYPE TIMESTAMP USING updated_at AT TIME ZONE current_setting('TimeZone'),
+ALTER COLUMN suspended_at TYPE TIMESTAMP USING suspended_at AT TIME ZONE current_setting('TimeZone');
+
+ALTER TABLE chirps
+ALTER COLUMN created_at TYPE TIMESTAMP USING created_at AT TIME ZONE current_setting('TimeZone'),
+ALTER COLUMN updated_at TYPE TIMESTAMP USING updated_at AT TIME ZONE current_setting('TimeZone');
+
+ALTER TABLE refresh_tokens
+ALTER COLUMN created_at TYPE TIMESTAMP USING created_at AT TIME ZONE current_setting('TimeZone'),
+ALTER COLUMN updated_at TYPE TIMESTAMP USING updated_at AT TIME ZONE current_setting('TimeZone'),
+ALTER COLUMN expires_at TYPE TIMESTAMP USING expires_at AT TIME ZONE current_setting('TimeZone'),
+ALTER COLUMN revoked_at TYPE TIMESTAMP USING revoked_at AT TIME ZONE current_setting('TimeZone');
+
+ALTER TABLE recovery_codes
+ALTER COLUMN created_at TYPE TIMESTAMP USING created_at AT TIME ZONE current_setting('TimeZone'),
+ALTER COLUMN used_at TYPE TIMESTAMP USING used_at AT TIME ZONE current_setting('TimeZone');
+
+ALTER TABLE oauth_clients
+ALTER COLUMN created_at TYPE TIMESTAMP USING created_at AT TIME ZONE current_setting('TimeZone'),
+ALTER COLUMN updated_at TYPE TIMESTAMP USING updated_at AT TIME ZONE current_setting('TimeZone');
+
+ALTER TABLE oauth_authorization_codes
+ALTER COLUMN created_at TYPE TIMESTAMP USING created_at AT TIME ZONE current_setting('TimeZone'),
+ALTER COLUMN expires_at TYPE TIMESTAMP USING expires_at AT TIME ZONE current_setting('TimeZone'),
+ALTER COLUMN used_at TYPE TIMESTAMP USING used_at AT TIME ZONE current_setting('TimeZone');
+
+ALTER TABLE oauth_consents
+ALTER COLUMN created_at TYPE TIMESTAMP USING created_at AT TIME ZONE current_setting('TimeZone'),
+ALTER COLUMN updated_at TYPE TIMESTAMP USING updated_at AT TIME ZONE current_setting('TimeZone');
+
+ALTER TABLE api_keys
+ALTER COLUMN created_at TYPE TIMESTAMP USING created_at AT TIME ZONE current_setting('TimeZone'),
+ALTER COLUMN expires_at TYPE TIMESTAMP USING expires_at AT TIME ZONE current_setting('TimeZone'),
+ALTER COLUMN last_used_at TYPE TIMESTAMP USING last_used_at AT TIME ZONE current_setting('TimeZone'),
+ALTER COLUMN revoked_at TYPE TIMESTAMP USING revoked_at AT TIME ZONE current_setting('TimeZone');
+
+ALTER TABLE oidc_identities
+ALTER COLUMN created_at TYPE TIMESTAMP USING created_at AT TIME ZONE current_setting('TimeZone');
+
+ALTER TABLE passkeys
+ALTER COLUMN created_at TYPE TIMESTAMP USING created_at AT TIME ZONE current_setting('TimeZone'),
+ALTER COLUMN updated_at TYPE TIMESTAMP USING updated_at AT TIME ZONE current_setting('TimeZone'),
+ALTER COLUMN last_used_at TYPE TIMESTAMP USING last_used_at AT TIME ZONE current_setting('TimeZone');
+
+ALTER TABLE webauthn_sessions
+ALTER COLUMN created_at TYPE TIMESTAMP USING created_at AT TIME ZONE current_setting('TimeZone'),
+ALTER COLUMN expires_at TYPE TIMESTAMP USING expires_at AT TIME ZONE current_setting('TimeZone');
+
+ALTER TABLE polka_events
+ALTER COLUMN received_at TYPE TIMESTAMP USING received_at AT TIME ZONE current_setting('TimeZone');
+
+ALTER TABLE subscriptions
+ALTER COLUMN created_at TYPE TIMESTAMP USING created_at AT TIME ZONE current_setting('TimeZone'),
+ALTER COLUMN updated_at TYPE TIMESTAMP USING updated_at AT TIME ZONE current_setting('TimeZone'),
+ALTER COLUMN current_period_end TYPE TIMESTAMP USING current_period_end AT TIME ZONE current_setting('TimeZone');
+
+ALTER TABLE subscription_events
+ALTER COLUMN current_period_end TYPE TIMESTAMP USING current_period_end AT TIME ZONE current_setting('TimeZone'),
+ALTER COLUMN created_at TYPE TIMESTAMP USING created_at AT TIME ZONE current_setting('TimeZone');
+
+ALTER TABLE webhook_endpoints
+ALTER COLUMN created_at TYPE TIMESTAMP USING created_at AT TIME ZONE current_setting('TimeZone'),
+ALTER COLUMN updated_at TYPE TIMESTAMP USING updated_at AT TIME ZONE current_setting('TimeZone');
+
+ALTER TABLE webhook_deliveries
+ALTER COLUMN created_at TYPE TIMESTAMP USING created_at AT TIME ZONE current_setting('TimeZone'),
+ALTER COLUMN updated_at TYPE TIMESTAMP USING updated_at AT TIME ZONE current_setting('TimeZone'),
+ALTER COLUMN next_attempt_at TYPE TIMESTAMP USING next_attempt_at AT TIME ZONE current_setting('TimeZone'),
+ALTER COLUMN last_attempt_at TYPE TIMESTAMP USING last_attempt_at AT TIME ZONE current_setting('TimeZone');
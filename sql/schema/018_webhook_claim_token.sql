@@ -0,0 +1,8 @@
+-- +goose Up
+-- set by each claim so only the worker holding the lease records the attempt
+ALTER TABLE webhook_deliveries
+ADD COLUMN claim_token UUID;
+
+-- +goose Down
+ALTER TABLE webhook_deliveries
+DROP COLUMN claim_token;
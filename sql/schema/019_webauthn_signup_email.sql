@@ -0,0 +1,17 @@
+-- +goose Up
+-- a passkey signup keeps its email here until the first passkey is
+-- registered, so no account exists before it can be logged in to
+ALTER TABLE webauthn_sessions
+ADD COLUMN email TEXT;
+
+-- accounts left behind by signups that never registered a passkey; they have
+-- no password, passkey or OIDC identity, so nobody can log in to them
+DELETE FROM users
+WHERE hashed_password = 'unset'
+AND NOT email_verified
+AND NOT EXISTS (SELECT 1 FROM passkeys WHERE passkeys.user_id = users.id)
+AND NOT EXISTS (SELECT 1 FROM oidc_identities WHERE oidc_identities.user_id = users.id);
+
+-- +goose Down
+ALTER TABLE webauthn_sessions
+DROP COLUMN email;
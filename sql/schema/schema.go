@@ -0,0 +1,8 @@
+// Package schema embeds the goose migrations so the binary can apply them
+// without the goose CLI or a checkout of the repo.
+package schema
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
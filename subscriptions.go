@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/raffkelly/chirpy/internal/database"
+)
+
+const (
+	subscriptionActive   = "active"
+	subscriptionPastDue  = "past_due"
+	subscriptionCanceled = "canceled"
+	subscriptionRefunded = "refunded"
+	subscriptionExpired  = "expired"
+
+	// used when Polka does not say when the paid period ends
+	subscriptionPeriod = 30 * 24 * time.Hour
+)
+
+type Subscription struct {
+	Status             string     `json:"status"`
+	Is_Chirpy_Red      bool       `json:"is_chirpy_red"`
+	Current_Period_End *time.Time `json:"current_period_end"`
+	CreatedAt          time.Time  `json:"created_at"`
+	UpdatedAt          time.Time  `json:"updated_at"`
+}
+
+type subscriptionState struct {
+	Status    string
+	PeriodEnd sql.NullTime
+}
+
+// grantsChirpyRed reports whether a member in this state keeps Chirpy Red.
+// Cancelled and past-due members keep it until the paid period runs out.
+func (s subscriptionState) grantsChirpyRed(now time.Time) bool {
+	switch s.Status {
+	case subscriptionActive, subscriptionPastDue, subscriptionCanceled:
+		return !s.PeriodEnd.Valid || s.PeriodEnd.Time.After(now)
+	}
+	return false
+}
+
+// nextSubscriptionState applies a Polka event to the current state, which is
+// nil for users without a subscription. periodEnd is the end of the paid
+// period when the event carries one. ok is false for events that do not
+// concern subscriptions.
+func nextSubscriptionState(event string, current *subscriptionState, periodEnd sql.NullTime, now time.Time) (next subscriptionState, ok bool) {
+	currentEnd := sql.NullTime{Time: now, Valid: true}
+	// grandfathered members have no period end; carrying that over would
+	// let a cancelled or unpaid membership last forever
+	if current != nil && current.PeriodEnd.Valid {
+		currentEnd = current.PeriodEnd
+	}
+	switch event {
+	case "user.upgraded":
+		if !periodEnd.Valid {
+			periodEnd = sql.NullTime{Time: now.Add(subscriptionPeriod), Valid: true}
+		}
+		return subscriptionState{Status: subscriptionActive, PeriodEnd: periodEnd}, true
+	case "subscription.renewed":
+		if !periodEnd.Valid {
+			start := now
+			if currentEnd.Valid && currentEnd.Time.After(now) {
+				start = currentEnd.Time
+			}
+			periodEnd = sql.NullTime{Time: start.Add(subscriptionPeriod), Valid: true}
+		}
+		return subscriptionState{Status: subscriptionActive, PeriodEnd: periodEnd}, true
+	case "subscription.canceled":
+		return subscriptionState{Status: subscriptionCanceled, PeriodEnd: currentEnd}, true
+	case "subscription.payment_failed":
+		return subscriptionState{Status: subscriptionPastDue, PeriodEnd: currentEnd}, true
+	case "subscription.refunded":
+		return subscriptionState{Status: subscriptionRefunded, PeriodEnd: sql.NullTime{Time: now, Valid: true}}, true
+	}
+	return subscriptionState{}, false
+}
+
+// applySubscriptionEvent updates the user's subscription and Chirpy Red flag
+// for a Polka event, recording it in the subscription history. It runs
+// inside the webhook's transaction.
+func applySubscriptionEvent(ctx context.Context, qtx *database.Queries, event polkaEvent) error {
+	// acknowledge unrelated events before validating a payload they needn't
+	// have, or Polka would retry them forever
+	if _, ok := nextSubscriptionState(event.Event, nil, sql.NullTime{}, time.Now()); !ok {
+		return nil
+	}
+	userID, err := uuid.Parse(event.Data["user_id"])
+	if err != nil {
+		return errors.Join(errPolkaBadPayload, err)
+	}
+	periodEnd := sql.NullTime{}
+	if raw := event.Data["period_end"]; raw != "" {
+		periodEnd.Time, err = time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return errors.Join(errPolkaBadPayload, err)
+		}
+		periodEnd.Valid = true
+	}
+	var current *subscriptionState
+	existing, err := qtx.GetSubscriptionByUserID(ctx, userID)
+	if err == nil {
+		current = &subscriptionState{Status: existing.Status, PeriodEnd: existing.CurrentPeriodEnd}
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	now := time.Now().UTC()
+	next, ok := nextSubscriptionState(event.Event, current, periodEnd, now)
+	if !ok {
+		return nil
+	}
+	subscriptionParams := database.UpsertSubscriptionParams{
+		UserID:           userID,
+		Status:           next.Status,
+		CurrentPeriodEnd: next.PeriodEnd,
+	}
+	subscription, err := qtx.UpsertSubscription(ctx, subscriptionParams)
+	if err != nil {
+		return err
+	}
+	historyParams := database.CreateSubscriptionEventParams{
+		SubscriptionID:   subscription.ID,
+		PolkaEventID:     event.ID,
+		Event:            event.Event,
+		Status:           next.Status,
+		CurrentPeriodEnd: next.PeriodEnd,
+	}
+	err = qtx.CreateSubscriptionEvent(ctx, historyParams)
+	if err != nil {
+		return err
+	}
+	redParams := database.SetUserChirpyRedParams{
+		ID:          userID,
+		IsChirpyRed: next.grantsChirpyRed(now),
+	}
+	return qtx.SetUserChirpyRed(ctx, redParams)
+}
+
+// expireSubscriptionsEvery periodically ends subscriptions whose paid period
+// has run out and takes Chirpy Red away from their users, until ctx is done.
+func (cfg *apiConfig) expireSubscriptionsEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		expired, err := cfg.dbQueries.ExpireSubscriptions(ctx)
+		if err != nil {
+			slog.ErrorContext(ctx, "error expiring subscriptions", "error", err)
+			continue
+		}
+		if len(expired) > 0 {
+			slog.InfoContext(ctx, "expired subscriptions", "count", len(expired))
+		}
+	}
+}
+
+func (cfg *apiConfig) handleGetSubscription(w http.ResponseWriter, r *http.Request) {
+	userID := currentPrincipal(r).UserID
+	subscription, err := cfg.dbQueries.GetSubscriptionByUserID(r.Context(), userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "no subscription", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "error retrieving subscription from db", err)
+		return
+	}
+	state := subscriptionState{Status: subscription.Status, PeriodEnd: subscription.CurrentPeriodEnd}
+	respondWithJSON(w, 200, Subscription{
+		Status:             subscription.Status,
+		Is_Chirpy_Red:      state.grantsChirpyRed(time.Now()),
+		Current_Period_End: nullTimePtr(subscription.CurrentPeriodEnd),
+		CreatedAt:          subscription.CreatedAt,
+		UpdatedAt:          subscription.UpdatedAt,
+	})
+}
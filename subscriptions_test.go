@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/raffkelly/chirpy/internal/database"
+)
+
+func TestNextSubscriptionState(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) sql.NullTime { return sql.NullTime{Time: now.Add(d), Valid: true} }
+	active := &subscriptionState{Status: subscriptionActive, PeriodEnd: at(10 * 24 * time.Hour)}
+
+	cases := []struct {
+		name      string
+		event     string
+		current   *subscriptionState
+		periodEnd sql.NullTime
+		status    string
+		end       sql.NullTime
+		red       bool
+	}{
+		{"upgrade", "user.upgraded", nil, sql.NullTime{}, subscriptionActive, at(subscriptionPeriod), true},
+		{"upgrade with period", "user.upgraded", nil, at(time.Hour), subscriptionActive, at(time.Hour), true},
+		{"early renewal extends period", "subscription.renewed", active, sql.NullTime{}, subscriptionActive, at(10*24*time.Hour + subscriptionPeriod), true},
+		{"cancel keeps paid period", "subscription.canceled", active, sql.NullTime{}, subscriptionCanceled, active.PeriodEnd, true},
+		{"payment failure keeps paid period", "subscription.payment_failed", active, sql.NullTime{}, subscriptionPastDue, active.PeriodEnd, true},
+		{"refund ends now", "subscription.refunded", active, sql.NullTime{}, subscriptionRefunded, at(0), false},
+		{"cancel without subscription", "subscription.canceled", nil, sql.NullTime{}, subscriptionCanceled, at(0), false},
+		{"grandfathered cancel ends now", "subscription.canceled", &subscriptionState{Status: subscriptionActive}, sql.NullTime{}, subscriptionCanceled, at(0), false},
+		{"grandfathered payment failure ends now", "subscription.payment_failed", &subscriptionState{Status: subscriptionActive}, sql.NullTime{}, subscriptionPastDue, at(0), false},
+	}
+	for _, c := range cases {
+		next, ok := nextSubscriptionState(c.event, c.current, c.periodEnd, now)
+		if !ok {
+			t.Errorf("%s: event ignored", c.name)
+			continue
+		}
+		if next.Status != c.status || next.PeriodEnd != c.end {
+			t.Errorf("%s: got %s until %v", c.name, next.Status, next.PeriodEnd)
+		}
+		if next.grantsChirpyRed(now) != c.red {
+			t.Errorf("%s: chirpy red should be %v", c.name, c.red)
+		}
+	}
+	if _, ok := nextSubscriptionState("user.deleted", active, sql.NullTime{}, now); ok {
+		t.Fatalf("unrelated event changed subscription")
+	}
+}
+
+func TestGrantsChirpyRed(t *testing.T) {
+	now := time.Now()
+	grandfathered := subscriptionState{Status: subscriptionActive}
+	lapsed := subscriptionState{Status: subscriptionCanceled, PeriodEnd: sql.NullTime{Time: now.Add(-time.Minute), Valid: true}}
+	expired := subscriptionState{Status: subscriptionExpired}
+	if !grandfathered.grantsChirpyRed(now) || lapsed.grantsChirpyRed(now) || expired.grantsChirpyRed(now) {
+		t.Fatalf("wrong chirpy red entitlement")
+	}
+}
+
+func TestApplySubscriptionEventIgnoresUnrelatedEvents(t *testing.T) {
+	event := polkaEvent{ID: "evt_1", Event: "user.deleted", Data: map[string]string{}}
+	err := applySubscriptionEvent(context.Background(), database.New(&fakeDB{}), event)
+	if err != nil {
+		t.Fatalf("unrelated event without user_id: %v", err)
+	}
+}
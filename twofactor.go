@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/raffkelly/chirpy/internal/auth"
+	"github.com/raffkelly/chirpy/internal/database"
+)
+
+const (
+	twoFactorChallengeTTL = 5 * time.Minute
+	recoveryCodeCount     = 10
+)
+
+// verifySecondFactor accepts either a current TOTP code or an unused recovery
+// code. Each TOTP step and each recovery code can only be used once.
+func (cfg *apiConfig) verifySecondFactor(ctx context.Context, user database.User, code, recoveryCode string) error {
+	if !user.TotpSecret.Valid {
+		return errors.New("totp not enrolled")
+	}
+	if recoveryCode != "" {
+		useParams := database.UseRecoveryCodeParams{
+			UserID:   user.ID,
+			CodeHash: auth.HashRecoveryCode(recoveryCode),
+		}
+		rows, err := cfg.dbQueries.UseRecoveryCode(ctx, useParams)
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			return errors.New("invalid recovery code")
+		}
+		return nil
+	}
+	step, err := auth.ValidateTOTP(user.TotpSecret.String, code, time.Now())
+	if err != nil {
+		return err
+	}
+	stepParams := database.UseTOTPStepParams{
+		TotpLastStep: step,
+		ID:           user.ID,
+	}
+	rows, err := cfg.dbQueries.UseTOTPStep(ctx, stepParams)
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return errors.New("totp code already used")
+	}
+	return nil
+}
+
+func (cfg *apiConfig) replaceRecoveryCodes(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	codes, err := auth.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := cfg.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+	qtx := cfg.dbQueries.WithTx(tx)
+	err = qtx.DeleteRecoveryCodes(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, code := range codes {
+		codeParams := database.CreateRecoveryCodeParams{
+			UserID:   userID,
+			CodeHash: auth.HashRecoveryCode(code),
+		}
+		err = qtx.CreateRecoveryCode(ctx, codeParams)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return codes, tx.Commit(ctx)
+}
+
+func (cfg *apiConfig) handleEnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	userID := currentPrincipal(r).UserID
+	user, err := cfg.dbQueries.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "user not found", err)
+		return
+	}
+	if user.TotpEnabled {
+		respondWithError(w, http.StatusConflict, "two-factor authentication already enabled", nil)
+		return
+	}
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "error generating totp secret", err)
+		return
+	}
+	secretParams := database.SetUserTOTPSecretParams{
+		TotpSecret: nullString(secret),
+		ID:         userID,
+	}
+	err = cfg.dbQueries.SetUserTOTPSecret(r.Context(), secretParams)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "error saving totp secret", err)
+		return
+	}
+	type response struct {
+		Secret          string `json:"secret"`
+		ProvisioningURI string `json:"provisioning_uri"`
+	}
+	respondWithJSON(w, http.StatusCreated, response{
+		Secret:          secret,
+		ProvisioningURI: auth.TOTPProvisioningURI(secret, user.Email),
+	})
+}
+
+func (cfg *apiConfig) handleConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	userID := currentPrincipal(r).UserID
+	type parameters struct {
+		Code string `json:"code"`
+	}
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "error decoding", err)
+		return
+	}
+	user, err := cfg.dbQueries.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "user not found", err)
+		return
+	}
+	if user.TotpEnabled {
+		respondWithError(w, http.StatusConflict, "two-factor authentication already enabled", nil)
+		return
+	}
+	err = cfg.verifySecondFactor(r.Context(), user, params.Code, "")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid code", err)
+		return
+	}
+	codes, err := cfg.replaceRecoveryCodes(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "error creating recovery codes", err)
+		return
+	}
+	err = cfg.dbQueries.EnableUserTOTP(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "error enabling totp", err)
+		return
+	}
+	type response struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	respondWithJSON(w, 200, response{RecoveryCodes: codes})
+}
+
+func (cfg *apiConfig) handleRegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	userID := currentPrincipal(r).UserID
+	type parameters struct {
+		Code string `json:"code"`
+	}
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "error decoding", err)
+		return
+	}
+	user, err := cfg.dbQueries.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "user not found", err)
+		return
+	}
+	if !user.TotpEnabled {
+		respondWithError(w, http.StatusBadRequest, "two-factor authentication not enabled", nil)
+		return
+	}
+	err = cfg.verifySecondFactor(r.Context(), user, params.Code, "")
+	if err != nil {
+		respondWithError(w, 401, "invalid code", err)
+		return
+	}
+	codes, err := cfg.replaceRecoveryCodes(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "error creating recovery codes", err)
+		return
+	}
+	type response struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	respondWithJSON(w, 200, response{RecoveryCodes: codes})
+}
+
+func (cfg *apiConfig) handleDisableTOTP(w http.ResponseWriter, r *http.Request) {
+	userID := currentPrincipal(r).UserID
+	type parameters struct {
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "error decoding", err)
+		return
+	}
+	user, err := cfg.dbQueries.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "user not found", err)
+		return
+	}
+	if !user.TotpEnabled {
+		respondWithError(w, http.StatusBadRequest, "two-factor authentication not enabled", nil)
+		return
+	}
+	err = cfg.verifySecondFactor(r.Context(), user, params.Code, params.RecoveryCode)
+	if err != nil {
+		respondWithError(w, 401, "invalid code", err)
+		return
+	}
+	err = cfg.dbQueries.DisableUserTOTP(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "error disabling totp", err)
+		return
+	}
+	err = cfg.dbQueries.DeleteRecoveryCodes(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "error deleting recovery codes", err)
+		return
+	}
+	respondWithJSON(w, 204, nil)
+}
+
+func (cfg *apiConfig) handleLoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string `json:"code"`
+		RecoveryCode   string `json:"recovery_code"`
+	}
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "error decoding", err)
+		return
+	}
+	userID, err := auth.ValidateTwoFactorChallengeToken(params.ChallengeToken, cfg.secret)
+	if err != nil {
+		respondWithError(w, 401, "invalid or expired challenge token", err)
+		return
+	}
+	user, err := cfg.dbQueries.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, 401, "invalid or expired challenge token", err)
+		return
+	}
+	if !user.TotpEnabled {
+		respondWithError(w, 401, "invalid or expired challenge token", nil)
+		return
+	}
+	// codes are only six digits, so share the password lockout
+	if !cfg.checkLoginThrottle(w, r, user.Email) {
+		return
+	}
+	err = cfg.verifySecondFactor(r.Context(), user, params.Code, params.RecoveryCode)
+	if err != nil {
+		cfg.recordLoginFailure(r, user.Email)
+		respondWithError(w, 401, "invalid code", err)
+		return
+	}
+	cfg.recordLoginSuccess(user.Email)
+	cfg.respondWithSession(w, r, user)
+}
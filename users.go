@@ -1,24 +1,46 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/raffkelly/chirpy/internal/auth"
 	"github.com/raffkelly/chirpy/internal/database"
 )
 
 type User struct {
-	ID            uuid.UUID `json:"id"`
-	CreatedAt     time.Time `json:"created_at"`
-	UpdatedAt     time.Time `json:"updated_at"`
-	Email         string    `json:"email"`
-	Token         string    `json:"token"`
-	Refresh_Token string    `json:"refresh_token"`
-	Is_Chirpy_Red bool      `json:"is_chirpy_red"`
+	ID             uuid.UUID `json:"id"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+	Email          string    `json:"email"`
+	Token          string    `json:"token"`
+	Refresh_Token  string    `json:"refresh_token"`
+	Is_Chirpy_Red  bool      `json:"is_chirpy_red"`
+	Email_Verified bool      `json:"email_verified"`
+	Pending_Email  string    `json:"pending_email,omitempty"`
+	TOTP_Enabled   bool      `json:"totp_enabled"`
+}
+
+func userFromDB(user database.User) User {
+	return User{
+		ID:             user.ID,
+		CreatedAt:      user.CreatedAt,
+		UpdatedAt:      user.UpdatedAt,
+		Email:          user.Email,
+		Is_Chirpy_Red:  user.IsChirpyRed,
+		Email_Verified: user.EmailVerified,
+		Pending_Email:  user.PendingEmail.String,
+		TOTP_Enabled:   user.TotpEnabled,
+	}
 }
 
 func (cfg *apiConfig) handlerCreateUser(w http.ResponseWriter, r *http.Request) {
@@ -35,36 +57,91 @@ func (cfg *apiConfig) handlerCreateUser(w http.ResponseWriter, r *http.Request)
 		respondWithError(w, http.StatusInternalServerError, "error decoding", err)
 		return
 	}
-	if params.Email == "" || (!strings.Contains(params.Email, "@")) {
-		respondWithError(w, http.StatusBadRequest, "provided email improper", nil)
+	databaseUserEntry, err := cfg.createUser(r.Context(), params.Email, params.Password)
+	var invalid invalidInputError
+	if errors.As(err, &invalid) {
+		respondWithError(w, http.StatusBadRequest, invalid.Error(), nil)
+		return
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		respondWithError(w, http.StatusConflict, "an account with this email already exists", err)
 		return
 	}
-	hashedPW, err := auth.HashPassword(params.Password)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "error hasing password", err)
+		respondWithError(w, http.StatusInternalServerError, "error creating user in database", err)
 		return
 	}
+	mainUser := userFromDB(databaseUserEntry)
 
+	respondWithJSON(w, http.StatusCreated, mainUser)
+}
+
+// invalidInputError is a rule violation to report back to whoever made the
+// request, as opposed to an internal failure.
+type invalidInputError string
+
+func (e invalidInputError) Error() string {
+	return string(e)
+}
+
+// createUser signs up a password user, applying the same rules whether the
+// account comes from the API or the admin command.
+func (cfg *apiConfig) createUser(ctx context.Context, email, password string) (database.User, error) {
+	if email == "" || (!strings.Contains(email, "@")) {
+		return database.User{}, invalidInputError("provided email improper")
+	}
+	err := cfg.passwordPolicy.Validate(password)
+	if err != nil {
+		return database.User{}, invalidInputError(err.Error())
+	}
+	hashedPW, err := auth.HashPassword(password)
+	if err != nil {
+		return database.User{}, fmt.Errorf("error hashing password: %w", err)
+	}
 	userParams := database.CreateUserParams{
-		Email:          params.Email,
+		Email:          email,
 		HashedPassword: hashedPW,
 	}
-	databaseUserEntry, err := cfg.dbQueries.CreateUser(r.Context(), userParams)
-
+	tx, err := cfg.db.Begin(ctx)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "error creating user in database", err)
-		return
+		return database.User{}, err
 	}
-
-	mainUser := User{
-		ID:            databaseUserEntry.ID,
-		CreatedAt:     databaseUserEntry.CreatedAt,
-		UpdatedAt:     databaseUserEntry.UpdatedAt,
-		Email:         databaseUserEntry.Email,
-		Is_Chirpy_Red: databaseUserEntry.IsChirpyRed,
+	defer tx.Rollback(ctx)
+	qtx := cfg.dbQueries.WithTx(tx)
+	user, err := qtx.CreateUser(ctx, userParams)
+	if err != nil {
+		return database.User{}, err
+	}
+	queued, err := queueUserCreated(ctx, qtx, user)
+	if err != nil {
+		return database.User{}, err
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		return database.User{}, err
+	}
+	err = cfg.sendVerificationEmail(ctx, user.ID, user.Email)
+	if err != nil {
+		slog.ErrorContext(ctx, "error sending verification email", "error", err)
 	}
+	cfg.announceUserCreated(ctx, queued)
+	return user, nil
+}
 
-	respondWithJSON(w, http.StatusCreated, mainUser)
+// queueUserCreated queues the user.created webhook event in the transaction
+// that creates the account, whichever way the account was created.
+func queueUserCreated(ctx context.Context, q *database.Queries, user database.User) (bool, error) {
+	return queueEvent(ctx, q, eventUserCreated, userFromDB(user))
+}
+
+// announceUserCreated counts a new account once the transaction creating it
+// has committed, and wakes the webhook workers if its event was queued.
+func (cfg *apiConfig) announceUserCreated(ctx context.Context, queued bool) {
+	cfg.metrics.UsersCreated.Inc()
+	if queued {
+		cfg.wakeWebhookWorkers(ctx)
+	}
 }
 
 func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
@@ -79,26 +156,100 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusInternalServerError, "login parameter decoding failed", err)
 		return
 	}
+	if !cfg.checkLoginThrottle(w, r, params.Email) {
+		return
+	}
 
 	user, err := cfg.dbQueries.GetUserFromEmail(r.Context(), params.Email)
+	if errors.Is(err, sql.ErrNoRows) {
+		auth.CheckDummyPasswordHash(params.Password)
+		cfg.recordLoginFailure(r, params.Email)
+		respondWithError(w, 401, "Incorrect email or password", err)
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "unable to find user", err)
 		return
 	}
 
+	// passwordless accounts hash a dummy too, so timing doesn't tell them
+	// apart from password accounts or unknown emails
+	if user.HashedPassword == unsetPassword {
+		auth.CheckDummyPasswordHash(params.Password)
+		cfg.recordLoginFailure(r, params.Email)
+		respondWithError(w, 401, "Incorrect email or password", nil)
+		return
+	}
 	err = auth.CheckPasswordHash(user.HashedPassword, params.Password)
 	if err != nil {
+		cfg.recordLoginFailure(r, params.Email)
 		respondWithError(w, 401, "Incorrect email or password", err)
 		return
 	}
+	if auth.NeedsRehash(user.HashedPassword) {
+		cfg.rehashPassword(r.Context(), user.ID, params.Password)
+	}
+	cfg.completeLogin(w, r, user)
+}
+
+// completeLogin finishes any first-factor login: users with two-factor
+// enabled get a challenge, everyone else gets a session.
+func (cfg *apiConfig) completeLogin(w http.ResponseWriter, r *http.Request, user database.User) {
+	if user.TotpEnabled {
+		challengeToken, err := auth.MakeTwoFactorChallengeToken(user.ID, cfg.secret, twoFactorChallengeTTL)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "error creating challenge token", err)
+			return
+		}
+		type challengeResponse struct {
+			TwoFactorRequired bool   `json:"two_factor_required"`
+			ChallengeToken    string `json:"challenge_token"`
+		}
+		respondWithJSON(w, 200, challengeResponse{
+			TwoFactorRequired: true,
+			ChallengeToken:    challengeToken,
+		})
+		return
+	}
+	cfg.recordLoginSuccess(user.Email)
+	cfg.respondWithSession(w, r, user)
+}
+
+// rehashPassword upgrades a stored hash to the current settings. Failure is
+// only logged since the login itself already succeeded.
+func (cfg *apiConfig) rehashPassword(ctx context.Context, userID uuid.UUID, password string) {
+	hashedPW, err := auth.HashPassword(password)
+	if err != nil {
+		slog.ErrorContext(ctx, "error rehashing password", "error", err)
+		return
+	}
+	userParams := database.UpdateUserPasswordParams{
+		HashedPassword: hashedPW,
+		ID:             userID,
+	}
+	_, err = cfg.dbQueries.UpdateUserPassword(ctx, userParams)
+	if err != nil {
+		slog.ErrorContext(ctx, "error saving rehashed password", "error", err)
+	}
+}
+
+// respondWithSession issues an access and refresh token pair for a fully
+// authenticated user.
+func (cfg *apiConfig) respondWithSession(w http.ResponseWriter, r *http.Request, user database.User) {
+	if user.SuspendedAt.Valid {
+		respondWithError(w, http.StatusForbidden, "account suspended", nil)
+		return
+	}
 	token, err := auth.MakeJWT(user.ID, cfg.secret, time.Hour)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "error creating token", err)
+		return
 	}
 
 	refreshToken, err := auth.MakeRefreshToken()
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "error creating refresh token", err)
+		return
 	}
 	refTokenParams := database.CreateRefreshTokenParams{
 		Token:  refreshToken,
@@ -107,33 +258,28 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 	_, err = cfg.dbQueries.CreateRefreshToken(r.Context(), refTokenParams)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "error creating refresh token db entry", err)
+		return
 	}
 
-	returnedUser := User{
-		ID:            user.ID,
-		CreatedAt:     user.CreatedAt,
-		UpdatedAt:     user.UpdatedAt,
-		Email:         user.Email,
-		Token:         token,
-		Refresh_Token: refreshToken,
-		Is_Chirpy_Red: user.IsChirpyRed,
-	}
+	returnedUser := userFromDB(user)
+	returnedUser.Token = token
+	returnedUser.Refresh_Token = refreshToken
 	respondWithJSON(w, 200, returnedUser)
 }
 
 func (cfg *apiConfig) handleRefresh(w http.ResponseWriter, r *http.Request) {
 	tokenString, err := auth.GetBearerToken(r.Header)
 	if err != nil {
-		respondWithError(w, 401, "refresh token not found", err)
+		respondWithAuthError(w, auth.SchemeBearer, err)
 		return
 	}
 	token, err := cfg.dbQueries.GetRefreshToken(r.Context(), tokenString)
 	if err != nil {
-		respondWithError(w, 401, "error getting token from db", err)
+		respondWithAuthError(w, auth.SchemeBearer, err)
 		return
 	}
 	if time.Now().After(token.ExpiresAt) || token.RevokedAt.Valid {
-		respondWithError(w, 401, "token has been revoked or is expired", err)
+		respondWithAuthError(w, auth.SchemeBearer, errors.New("refresh token has been revoked or is expired"))
 		return
 	}
 	newAccessToken, err := auth.MakeJWT(token.UserID, cfg.secret, time.Hour)
@@ -150,7 +296,7 @@ func (cfg *apiConfig) handleRefresh(w http.ResponseWriter, r *http.Request) {
 func (cfg *apiConfig) handleRevoke(w http.ResponseWriter, r *http.Request) {
 	tokenString, err := auth.GetBearerToken(r.Header)
 	if err != nil {
-		respondWithError(w, 401, "refresh token not found", err)
+		respondWithAuthError(w, auth.SchemeBearer, err)
 		return
 	}
 	err = cfg.dbQueries.RevokeToken(r.Context(), tokenString)
@@ -162,17 +308,7 @@ func (cfg *apiConfig) handleRevoke(w http.ResponseWriter, r *http.Request) {
 }
 
 func (cfg *apiConfig) handleUpdateUser(w http.ResponseWriter, r *http.Request) {
-	tokenString, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, 401, "token missing", err)
-		return
-	}
-	userID, err := auth.ValidateJWT(tokenString, cfg.secret)
-
-	if err != nil {
-		respondWithError(w, 401, "invalid token", err)
-		return
-	}
+	userID := currentPrincipal(r).UserID
 	type parameters struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
@@ -181,73 +317,69 @@ func (cfg *apiConfig) handleUpdateUser(w http.ResponseWriter, r *http.Request) {
 	params := parameters{}
 
 	decoder := json.NewDecoder(r.Body)
-	err = decoder.Decode(&params)
+	err := decoder.Decode(&params)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "error decoding", err)
 		return
 	}
-	if params.Email == "" || (!strings.Contains(params.Email, "@")) {
-		respondWithError(w, http.StatusBadRequest, "provided email improper", nil)
-		return
-	}
-	hashedPW, err := auth.HashPassword(params.Password)
+	updatedUser, err := cfg.dbQueries.GetUser(r.Context(), userID)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "error hasing password", err)
+		respondWithError(w, http.StatusNotFound, "user not found", err)
 		return
 	}
-
-	userParams := database.UpdateUserEmailPasswordParams{
-		Email:          params.Email,
-		HashedPassword: hashedPW,
-		ID:             userID,
-	}
-	updatedUser, err := cfg.dbQueries.UpdateUserEmailPassword(r.Context(), userParams)
-	returnedUser := User{
-		ID:            userID,
-		CreatedAt:     updatedUser.CreatedAt,
-		UpdatedAt:     updatedUser.UpdatedAt,
-		Email:         updatedUser.Email,
-		Is_Chirpy_Red: updatedUser.IsChirpyRed,
+	// omitted fields are left unchanged
+	if params.Email == "" {
+		params.Email = updatedUser.Email
 	}
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "error updating email and password in db", err)
+	if !strings.Contains(params.Email, "@") {
+		respondWithError(w, http.StatusBadRequest, "provided email improper", nil)
 		return
 	}
-	respondWithJSON(w, 200, returnedUser)
-
-}
+	if params.Password != "" {
+		err = cfg.passwordPolicy.Validate(params.Password)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+		hashedPW, err := auth.HashPassword(params.Password)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "error hasing password", err)
+			return
+		}
 
-func (cfg *apiConfig) handleUpgradeUser(w http.ResponseWriter, r *http.Request) {
-	apiKey, err := auth.GetAPIKey(r.Header)
-	if err != nil || apiKey != cfg.polka_key {
-		respondWithError(w, 401, "bad api key", err)
-		return
-	}
-	type parameters struct {
-		Event string            `json:"event"`
-		Data  map[string]string `json:"data"`
-	}
-	var params parameters
-	decoder := json.NewDecoder(r.Body)
-	err = decoder.Decode(&params)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "error decoding request body", err)
-		return
-	}
-	if params.Event != "user.upgraded" {
-		respondWithJSON(w, 204, nil)
-		return
-	} else if params.Event == "user.upgraded" {
-		userID, err := uuid.Parse(string(params.Data["user_id"]))
+		userParams := database.UpdateUserPasswordParams{
+			HashedPassword: hashedPW,
+			ID:             userID,
+		}
+		updatedUser, err = cfg.dbQueries.UpdateUserPassword(r.Context(), userParams)
 		if err != nil {
-			respondWithError(w, http.StatusInternalServerError, "unable to parse user id from request", err)
+			respondWithError(w, http.StatusInternalServerError, "error updating password in db", err)
 			return
 		}
-		err = cfg.dbQueries.UpgradeUser(r.Context(), userID)
+	}
+
+	// a new email only replaces the current one once it has been verified
+	if params.Email != updatedUser.Email || updatedUser.PendingEmail.Valid {
+		pendingEmail := ""
+		if params.Email != updatedUser.Email {
+			pendingEmail = params.Email
+		}
+		pendingParams := database.SetUserPendingEmailParams{
+			PendingEmail: nullString(pendingEmail),
+			ID:           userID,
+		}
+		updatedUser, err = cfg.dbQueries.SetUserPendingEmail(r.Context(), pendingParams)
 		if err != nil {
-			respondWithError(w, 404, "user not found", err)
+			respondWithError(w, http.StatusInternalServerError, "error updating email in db", err)
 			return
 		}
-		respondWithJSON(w, 204, nil)
+		if pendingEmail != "" {
+			err = cfg.sendVerificationEmail(r.Context(), userID, pendingEmail)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "error sending verification email", "error", err)
+			}
+		}
 	}
+	respondWithJSON(w, 200, userFromDB(updatedUser))
+
 }
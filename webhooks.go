@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/raffkelly/chirpy/internal/auth"
+	"github.com/raffkelly/chirpy/internal/database"
+	"github.com/raffkelly/chirpy/internal/webhook"
+)
+
+// Events integrators can subscribe to. "*" subscribes to all of them.
+const (
+	eventChirpCreated = "chirp.created"
+	eventChirpDeleted = "chirp.deleted"
+	eventUserCreated  = "user.created"
+)
+
+var webhookEvents = []string{eventChirpCreated, eventChirpDeleted, eventUserCreated}
+
+const (
+	webhookStatusPending   = "pending"
+	webhookStatusSucceeded = "succeeded"
+	webhookStatusDead      = "dead"
+
+	webhookMaxAttempts = 8
+	webhookBatchSize   = 20
+	webhookTimeout     = 10 * time.Second
+	// claimed deliveries are retried by another worker if not recorded by
+	// then; a batch is sent concurrently, so this only has to outlast one
+	// attempt with room to spare
+	webhookLease = 2 * time.Minute
+	// NOTIFY channel that wakes every instance's delivery worker
+	webhookNotifyChannel = "chirpy_webhook_deliveries"
+)
+
+type WebhookEndpoint struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Active    bool      `json:"active"`
+	// only returned when the endpoint is registered
+	Secret string `json:"secret,omitempty"`
+}
+
+type WebhookDelivery struct {
+	ID             uuid.UUID  `json:"id"`
+	CreatedAt      time.Time  `json:"created_at"`
+	EventID        uuid.UUID  `json:"event_id"`
+	Event          string     `json:"event"`
+	Status         string     `json:"status"`
+	Attempts       int32      `json:"attempts"`
+	NextAttemptAt  *time.Time `json:"next_attempt_at"`
+	LastAttemptAt  *time.Time `json:"last_attempt_at"`
+	LastStatusCode *int32     `json:"last_status_code"`
+	LastError      string     `json:"last_error,omitempty"`
+}
+
+func webhookEndpointFromDB(endpoint database.WebhookEndpoint) WebhookEndpoint {
+	return WebhookEndpoint{
+		ID:        endpoint.ID,
+		CreatedAt: endpoint.CreatedAt,
+		URL:       endpoint.Url,
+		Events:    endpoint.Events,
+		Active:    endpoint.Active,
+	}
+}
+
+func webhookDeliveryFromDB(delivery database.WebhookDelivery) WebhookDelivery {
+	response := WebhookDelivery{
+		ID:            delivery.ID,
+		CreatedAt:     delivery.CreatedAt,
+		EventID:       delivery.EventID,
+		Event:         delivery.Event,
+		Status:        delivery.Status,
+		Attempts:      delivery.Attempts,
+		LastAttemptAt: nullTimePtr(delivery.LastAttemptAt),
+		LastError:     delivery.LastError.String,
+	}
+	if delivery.Status == webhookStatusPending {
+		response.NextAttemptAt = &delivery.NextAttemptAt
+	}
+	if delivery.LastStatusCode.Valid {
+		response.LastStatusCode = &delivery.LastStatusCode.Int32
+	}
+	return response
+}
+
+// queueEvent queues an event for every endpoint subscribed to it. q should
+// belong to the transaction making the change, so the event is queued if and
+// only if the change commits. It reports whether any delivery was queued;
+// if so, call wakeWebhookWorkers after committing so the worker started by
+// deliverWebhooksEvery sends it right away.
+func queueEvent(ctx context.Context, q *database.Queries, event string, data interface{}) (bool, error) {
+	eventID := uuid.New()
+	payload, err := json.Marshal(struct {
+		ID        uuid.UUID   `json:"id"`
+		Event     string      `json:"event"`
+		CreatedAt time.Time   `json:"created_at"`
+		Data      interface{} `json:"data"`
+	}{eventID, event, time.Now().UTC(), data})
+	if err != nil {
+		return false, err
+	}
+	enqueueParams := database.EnqueueWebhookEventParams{
+		EventID: eventID,
+		Event:   event,
+		Payload: payload,
+	}
+	queued, err := q.EnqueueWebhookEvent(ctx, enqueueParams)
+	if err != nil {
+		return false, err
+	}
+	return queued > 0, nil
+}
+
+// wakeWebhookWorkers lets the delivery workers send new deliveries right
+// away instead of at their next poll. If it fails the poll still finds them.
+func (cfg *apiConfig) wakeWebhookWorkers(ctx context.Context) {
+	notifyParams := database.NotifyParams{
+		Channel: webhookNotifyChannel,
+	}
+	err := cfg.dbQueries.Notify(ctx, notifyParams)
+	if err != nil {
+		slog.WarnContext(ctx, "error waking webhook workers", "error", err)
+	}
+}
+
+// deliverWebhooksEvery polls for due deliveries and sends them, retrying
+// with exponential backoff until webhookMaxAttempts, after which the
+// delivery is dead-lettered until an admin replays it. A receive on wake
+// polls straight away. It stops when ctx is done; a batch already claimed is
+// finished first so no lease is left hanging.
+func (cfg *apiConfig) deliverWebhooksEvery(ctx context.Context, interval time.Duration, wake <-chan struct{}) {
+	client := &http.Client{Timeout: webhookTimeout}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-wake:
+		}
+		claimParams := database.ClaimWebhookDeliveriesParams{
+			LeaseSeconds: webhookLease.Seconds(),
+			ClaimToken:   uuid.New(),
+			BatchSize:    webhookBatchSize,
+		}
+		deliveries, err := cfg.dbQueries.ClaimWebhookDeliveries(ctx, claimParams)
+		if err != nil {
+			slog.ErrorContext(ctx, "error claiming webhook deliveries", "error", err)
+			continue
+		}
+		var batch sync.WaitGroup
+		for _, delivery := range deliveries {
+			batch.Add(1)
+			go func() {
+				defer batch.Done()
+				cfg.attemptWebhookDelivery(context.WithoutCancel(ctx), client, delivery)
+			}()
+		}
+		batch.Wait()
+	}
+}
+
+func (cfg *apiConfig) attemptWebhookDelivery(ctx context.Context, client *http.Client, delivery database.WebhookDelivery) {
+	endpoint, err := cfg.dbQueries.GetWebhookEndpoint(ctx, delivery.EndpointID)
+	if err != nil {
+		slog.ErrorContext(ctx, "error loading webhook endpoint", "endpoint_id", delivery.EndpointID, "delivery_id", delivery.ID, "error", err)
+		return
+	}
+	statusCode, sendErr := webhook.Send(ctx, client, webhook.Delivery{
+		ID:      delivery.ID.String(),
+		Event:   delivery.Event,
+		URL:     endpoint.Url,
+		Secret:  endpoint.Secret,
+		Payload: delivery.Payload,
+	})
+	attemptParams := database.RecordWebhookAttemptParams{
+		ID:             delivery.ID,
+		Status:         webhookStatusSucceeded,
+		NextAttemptAt:  time.Now(),
+		LastStatusCode: sql.NullInt32{Int32: int32(statusCode), Valid: statusCode != 0},
+		ClaimToken:     delivery.ClaimToken,
+	}
+	if sendErr != nil {
+		attempts := int(delivery.Attempts) + 1
+		attemptParams.Status = webhookStatusPending
+		attemptParams.NextAttemptAt = time.Now().Add(webhook.Backoff(attempts))
+		attemptParams.LastError = nullString(sendErr.Error())
+		if attempts >= webhookMaxAttempts {
+			attemptParams.Status = webhookStatusDead
+		}
+	}
+	cfg.metrics.WebhookDeliveries.WithLabelValues(attemptParams.Status).Inc()
+	recorded, err := cfg.dbQueries.RecordWebhookAttempt(ctx, attemptParams)
+	if err != nil {
+		slog.ErrorContext(ctx, "error recording webhook delivery", "delivery_id", delivery.ID, "error", err)
+		return
+	}
+	if recorded == 0 {
+		slog.WarnContext(ctx, "webhook delivery was claimed by another worker before its attempt was recorded", "delivery_id", delivery.ID)
+	}
+}
+
+func validWebhookURL(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	return u.Scheme == "https" || u.Scheme == "http"
+}
+
+func (cfg *apiConfig) handleCreateWebhookEndpoint(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "error decoding", err)
+		return
+	}
+	if !validWebhookURL(params.URL) {
+		respondWithError(w, http.StatusBadRequest, "url must be an absolute http or https url", nil)
+		return
+	}
+	if len(params.Events) == 0 {
+		respondWithError(w, http.StatusBadRequest, "at least one event required", nil)
+		return
+	}
+	for _, event := range params.Events {
+		if event != "*" && !slices.Contains(webhookEvents, event) {
+			respondWithError(w, http.StatusBadRequest, "unknown event "+event, nil)
+			return
+		}
+	}
+	secret, err := auth.MakeOpaqueToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "error generating secret", err)
+		return
+	}
+	endpointParams := database.CreateWebhookEndpointParams{
+		Url:    params.URL,
+		Secret: "whsec_" + secret,
+		Events: params.Events,
+	}
+	endpoint, err := cfg.dbQueries.CreateWebhookEndpoint(r.Context(), endpointParams)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "error creating webhook endpoint in db", err)
+		return
+	}
+	response := webhookEndpointFromDB(endpoint)
+	response.Secret = endpoint.Secret
+	respondWithJSON(w, http.StatusCreated, response)
+}
+
+func (cfg *apiConfig) handleListWebhookEndpoints(w http.ResponseWriter, r *http.Request) {
+	endpoints, err := cfg.dbQueries.GetWebhookEndpoints(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "error retrieving webhook endpoints from db", err)
+		return
+	}
+	response := make([]WebhookEndpoint, len(endpoints))
+	for i, endpoint := range endpoints {
+		response[i] = webhookEndpointFromDB(endpoint)
+	}
+	respondWithJSON(w, 200, response)
+}
+
+func (cfg *apiConfig) handleDeleteWebhookEndpoint(w http.ResponseWriter, r *http.Request) {
+	endpointID, err := uuid.Parse(r.PathValue("endpointID"))
+	if err != nil {
+		respondWithError(w, 404, "error parsing endpoint id", err)
+		return
+	}
+	deleted, err := cfg.dbQueries.DeleteWebhookEndpoint(r.Context(), endpointID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "error deleting webhook endpoint", err)
+		return
+	}
+	if deleted == 0 {
+		respondWithError(w, 404, "webhook endpoint not found", nil)
+		return
+	}
+	respondWithJSON(w, 204, nil)
+}
+
+func (cfg *apiConfig) handleListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	endpointID, err := uuid.Parse(r.PathValue("endpointID"))
+	if err != nil {
+		respondWithError(w, 404, "error parsing endpoint id", err)
+		return
+	}
+	limit := 50
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		limit, err = strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > 200 {
+			respondWithError(w, http.StatusBadRequest, "limit must be between 1 and 200", err)
+			return
+		}
+	}
+	deliveryParams := database.GetWebhookDeliveriesByEndpointParams{
+		EndpointID: endpointID,
+		Limit:      int32(limit),
+	}
+	deliveries, err := cfg.dbQueries.GetWebhookDeliveriesByEndpoint(r.Context(), deliveryParams)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "error retrieving deliveries from db", err)
+		return
+	}
+	response := make([]WebhookDelivery, len(deliveries))
+	for i, delivery := range deliveries {
+		response[i] = webhookDeliveryFromDB(delivery)
+	}
+	respondWithJSON(w, 200, response)
+}
+
+// handleReplayWebhookDelivery puts a dead-lettered delivery back in the queue
+// with a fresh set of attempts.
+func (cfg *apiConfig) handleReplayWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	deliveryID, err := uuid.Parse(r.PathValue("deliveryID"))
+	if err != nil {
+		respondWithError(w, 404, "error parsing delivery id", err)
+		return
+	}
+	delivery, err := cfg.dbQueries.ReplayWebhookDelivery(r.Context(), deliveryID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusConflict, "only dead deliveries can be replayed", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "error replaying delivery", err)
+		return
+	}
+	cfg.wakeWebhookWorkers(r.Context())
+	respondWithJSON(w, 200, webhookDeliveryFromDB(delivery))
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/raffkelly/chirpy/internal/database"
+	"github.com/raffkelly/chirpy/internal/metrics"
+)
+
+func TestValidWebhookURL(t *testing.T) {
+	cases := map[string]bool{
+		"https://hooks.example.com/chirpy": true,
+		"http://10.0.0.5:9000/events":      true,
+		"ftp://hooks.example.com":          false,
+		"/relative/path":                   false,
+		"https://":                         false,
+	}
+	for raw, expected := range cases {
+		if validWebhookURL(raw) != expected {
+			t.Errorf("webhook url %s: expected %v", raw, expected)
+		}
+	}
+}
+
+func TestWebhookLeaseOutlastsAttempt(t *testing.T) {
+	// a batch is sent concurrently, so one attempt plus the database round
+	// trips must finish well within the lease
+	if webhookLease < 2*webhookTimeout {
+		t.Fatalf("lease %s too short for a %s send timeout", webhookLease, webhookTimeout)
+	}
+}
+
+func TestChirpEventQueuedWithChirp(t *testing.T) {
+	cases := []struct {
+		name     string
+		enqueue  error
+		expected []string
+	}{
+		{"event queued", nil, []string{"CreateChirp", "EnqueueWebhookEvent", "Commit", "Notify"}},
+		{"queueing fails", errors.New("connection reset"), []string{"CreateChirp", "EnqueueWebhookEvent"}},
+	}
+	for _, c := range cases {
+		db := &fakeDB{}
+		db.on("CreateChirp", func(args ...any) (any, error) {
+			return database.Chirp{ID: uuid.New(), Body: args[0].(string), UserID: args[1].(uuid.UUID)}, nil
+		})
+		db.on("EnqueueWebhookEvent", func(args ...any) (any, error) { return int64(1), c.enqueue })
+		db.on("Notify", func(args ...any) (any, error) { return nil, nil })
+		cfg := &apiConfig{metrics: metrics.New(), db: db, dbQueries: database.New(db)}
+
+		chirpParams := database.CreateChirpParams{Body: "hello", UserID: uuid.New()}
+		_, err := cfg.createChirp(context.Background(), chirpParams)
+		if (err != nil) != (c.enqueue != nil) {
+			t.Errorf("%s: got error %v", c.name, err)
+		}
+		// a chirp is only committed with its event, and workers are woken after
+		if !slices.Equal(db.calls, c.expected) {
+			t.Errorf("%s: got calls %v, want %v", c.name, db.calls, c.expected)
+		}
+	}
+}